@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type (
+	// documentCache is the client side copy of the published documents, by collection and id,
+	// as merged from the added, changed and removed messages
+	documentCache map[string]map[string]map[string]json.RawMessage
+	// DocumentEvent tells that a document was added, changed or removed. Fields holds all the fields
+	// of the document after the change, it is nil for removed documents.
+	DocumentEvent struct {
+		Type       string
+		Collection string
+		ID         string
+		Fields     json.RawMessage
+	}
+)
+
+func (cache documentCache) add(msg *ddpAdded) error {
+	fields := make(map[string]json.RawMessage)
+	if len(msg.Fields) > 0 {
+		if err := json.Unmarshal(msg.Fields, &fields); err != nil {
+			return fmt.Errorf("in Unmarshal: %s", err)
+		}
+	}
+	docs, ok := cache[msg.Collection]
+	if !ok {
+		docs = make(map[string]map[string]json.RawMessage)
+		cache[msg.Collection] = docs
+	}
+	docs[msg.ID] = fields
+	return nil
+}
+
+// change merges the top level fields into the document and deletes the cleared ones
+func (cache documentCache) change(msg *ddpChanged) error {
+	fields, ok := cache[msg.Collection][msg.ID]
+	if !ok {
+		return fmt.Errorf("unknown document '%s' in collection '%s'", msg.ID, msg.Collection)
+	}
+	if len(msg.Fields) > 0 {
+		changed := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(msg.Fields, &changed); err != nil {
+			return fmt.Errorf("in Unmarshal: %s", err)
+		}
+		for name, value := range changed {
+			fields[name] = value
+		}
+	}
+	for _, name := range msg.Cleared {
+		delete(fields, name)
+	}
+	return nil
+}
+
+func (cache documentCache) remove(msg *ddpRemoved) {
+	delete(cache[msg.Collection], msg.ID)
+	if len(cache[msg.Collection]) == 0 {
+		delete(cache, msg.Collection)
+	}
+}
+
+func (cache documentCache) has(collection, id string) bool {
+	_, ok := cache[collection][id]
+	return ok
+}
+
+// getFields returns the current fields of the document as a JSON object
+func (cache documentCache) getFields(collection, id string) (json.RawMessage, error) {
+	buffer, err := json.Marshal(cache[collection][id])
+	if err != nil {
+		return nil, fmt.Errorf("in Marshal: %s", err)
+	}
+	return buffer, nil
+}
+
+// getDoc returns the current state of the document as an added message,
+// the form which the Raw* types are unmarshalled from
+func (cache documentCache) getDoc(collection, id string) (string, error) {
+	fields, err := cache.getFields(collection, id)
+	if err != nil {
+		return "", err
+	}
+	buffer, err := json.Marshal(&ddpAdded{
+		Msg:        "added",
+		Collection: collection,
+		ID:         id,
+		Fields:     fields,
+	})
+	if err != nil {
+		return "", fmt.Errorf("in Marshal: %s", err)
+	}
+	return string(buffer), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDocumentCache(t *testing.T) {
+	cache := make(documentCache)
+	tests := []struct {
+		msg string
+		doc string
+		err bool
+	}{
+		{
+			msg: `{"msg":"added","collection":"processes","id":"p1","fields":{"pid":1,"image":"a.exe","scores":{"specs":{"injects":true}}}}`,
+			doc: `{"msg":"added","collection":"processes","id":"p1","fields":{"image":"a.exe","pid":1,"scores":{"specs":{"injects":true}}}}`,
+		},
+		// top level fields are replaced as a whole
+		{
+			msg: `{"msg":"changed","collection":"processes","id":"p1","fields":{"image":"b.exe","scores":{"important":true}}}`,
+			doc: `{"msg":"added","collection":"processes","id":"p1","fields":{"image":"b.exe","pid":1,"scores":{"important":true}}}`,
+		},
+		{
+			msg: `{"msg":"changed","collection":"processes","id":"p1","fields":{"cmd":"b.exe -x"},"cleared":["scores","unknown"]}`,
+			doc: `{"msg":"added","collection":"processes","id":"p1","fields":{"cmd":"b.exe -x","image":"b.exe","pid":1}}`,
+		},
+		{
+			msg: `{"msg":"added","collection":"processes","id":"p2"}`,
+			doc: `{"msg":"added","collection":"processes","id":"p2","fields":{}}`,
+		},
+		{msg: `{"msg":"removed","collection":"processes","id":"p1"}`},
+		{msg: `{"msg":"changed","collection":"processes","id":"p1","fields":{"pid":2}}`, err: true},
+		{msg: `{"msg":"changed","collection":"tasks","id":"p2","fields":{"pid":2}}`, err: true},
+	}
+	for _, test := range tests {
+		msg, err := decodeDDPMessage([]byte(test.msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var id string
+		switch msg := msg.(type) {
+		case *ddpAdded:
+			id, err = msg.ID, cache.add(msg)
+		case *ddpChanged:
+			id, err = msg.ID, cache.change(msg)
+		case *ddpRemoved:
+			id = msg.ID
+			cache.remove(msg)
+		}
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: applied to an unknown document", test.msg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.msg, err)
+			continue
+		}
+		if test.doc == "" {
+			if cache.has("processes", id) {
+				t.Errorf("%s: document '%s' is kept", test.msg, id)
+			}
+			continue
+		}
+		doc, err := cache.getDoc("processes", id)
+		if err != nil {
+			t.Errorf("%s: %s", test.msg, err)
+		} else if doc != test.doc {
+			t.Errorf("%s: got %s, want %s", test.msg, doc, test.doc)
+		}
+	}
+	// the merged document is what the Raw* types are unmarshalled from
+	doc, _ := cache.getDoc("processes", "p2")
+	var process RawProcess
+	if err := json.Unmarshal([]byte(doc), &process); err != nil || process.ID != "p2" {
+		t.Errorf("failed to unmarshal %s: %v", doc, err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the filter values of the public tasks, names are as displayed by app.any.run and matched case-insensitively
+var (
+	SupportedTaskExtensions = map[string]string{
+		"PE EXE":           "0",
+		"PE DLL":           "1",
+		"Scripts":          "2",
+		"Archives":         "3",
+		"Email":            "4",
+		"PDF":              "5",
+		"Microsoft Office": "6",
+		"Java":             "7",
+		"APK":              "8",
+		"ELF":              "9",
+		"MSI":              "10",
+		"Other":            "11",
+	}
+	SupportedTaskDetections = map[string]int{
+		"No threats": 0,
+		"Suspicious": 1,
+		"Malicious":  2,
+	}
+	// SupportedTaskRuntypes are the ways a task is submitted: an uploaded file, a URL opened in a browser
+	// or a file downloaded from a URL
+	SupportedTaskRuntypes = map[string]string{
+		"file":     "file",
+		"url":      "url",
+		"download": "download",
+	}
+)
+
+// ATTCKTechniqueNames are the names of the enterprise ATT&CK techniques usually reported by app.any.run,
+// sub-techniques are named after their parent as in the MISP galaxy, e.g. "Process Injection: Process Hollowing"
+var ATTCKTechniqueNames = map[string]string{
+	"T1003":     "OS Credential Dumping",
+	"T1003.001": "OS Credential Dumping: LSASS Memory",
+	"T1005":     "Data from Local System",
+	"T1007":     "System Service Discovery",
+	"T1010":     "Application Window Discovery",
+	"T1012":     "Query Registry",
+	"T1016":     "System Network Configuration Discovery",
+	"T1018":     "Remote System Discovery",
+	"T1021":     "Remote Services",
+	"T1027":     "Obfuscated Files or Information",
+	"T1027.002": "Obfuscated Files or Information: Software Packing",
+	"T1033":     "System Owner/User Discovery",
+	"T1036":     "Masquerading",
+	"T1036.005": "Masquerading: Match Legitimate Name or Location",
+	"T1041":     "Exfiltration Over C2 Channel",
+	"T1047":     "Windows Management Instrumentation",
+	"T1048":     "Exfiltration Over Alternative Protocol",
+	"T1049":     "System Network Connections Discovery",
+	"T1053":     "Scheduled Task/Job",
+	"T1053.005": "Scheduled Task/Job: Scheduled Task",
+	"T1055":     "Process Injection",
+	"T1055.012": "Process Injection: Process Hollowing",
+	"T1056":     "Input Capture",
+	"T1057":     "Process Discovery",
+	"T1059":     "Command and Scripting Interpreter",
+	"T1059.001": "Command and Scripting Interpreter: PowerShell",
+	"T1059.003": "Command and Scripting Interpreter: Windows Command Shell",
+	"T1059.005": "Command and Scripting Interpreter: Visual Basic",
+	"T1059.007": "Command and Scripting Interpreter: JavaScript",
+	"T1068":     "Exploitation for Privilege Escalation",
+	"T1070":     "Indicator Removal",
+	"T1070.004": "Indicator Removal: File Deletion",
+	"T1071":     "Application Layer Protocol",
+	"T1071.001": "Application Layer Protocol: Web Protocols",
+	"T1082":     "System Information Discovery",
+	"T1083":     "File and Directory Discovery",
+	"T1087":     "Account Discovery",
+	"T1090":     "Proxy",
+	"T1095":     "Non-Application Layer Protocol",
+	"T1098":     "Account Manipulation",
+	"T1102":     "Web Service",
+	"T1105":     "Ingress Tool Transfer",
+	"T1106":     "Native API",
+	"T1112":     "Modify Registry",
+	"T1113":     "Screen Capture",
+	"T1114":     "Email Collection",
+	"T1115":     "Clipboard Data",
+	"T1119":     "Automated Collection",
+	"T1120":     "Peripheral Device Discovery",
+	"T1124":     "System Time Discovery",
+	"T1129":     "Shared Modules",
+	"T1134":     "Access Token Manipulation",
+	"T1135":     "Network Share Discovery",
+	"T1136":     "Create Account",
+	"T1140":     "Deobfuscate/Decode Files or Information",
+	"T1197":     "BITS Jobs",
+	"T1203":     "Exploitation for Client Execution",
+	"T1204":     "User Execution",
+	"T1204.002": "User Execution: Malicious File",
+	"T1210":     "Exploitation of Remote Services",
+	"T1218":     "System Binary Proxy Execution",
+	"T1218.011": "System Binary Proxy Execution: Rundll32",
+	"T1219":     "Remote Access Software",
+	"T1222":     "File and Directory Permissions Modification",
+	"T1485":     "Data Destruction",
+	"T1486":     "Data Encrypted for Impact",
+	"T1489":     "Service Stop",
+	"T1490":     "Inhibit System Recovery",
+	"T1496":     "Resource Hijacking",
+	"T1497":     "Virtualization/Sandbox Evasion",
+	"T1497.001": "Virtualization/Sandbox Evasion: System Checks",
+	"T1518":     "Software Discovery",
+	"T1529":     "System Shutdown/Reboot",
+	"T1543":     "Create or Modify System Process",
+	"T1543.003": "Create or Modify System Process: Windows Service",
+	"T1547":     "Boot or Logon Autostart Execution",
+	"T1547.001": "Boot or Logon Autostart Execution: Registry Run Keys / Startup Folder",
+	"T1548":     "Abuse Elevation Control Mechanism",
+	"T1548.002": "Abuse Elevation Control Mechanism: Bypass User Account Control",
+	"T1552":     "Unsecured Credentials",
+	"T1555":     "Credentials from Password Stores",
+	"T1555.003": "Credentials from Password Stores: Credentials from Web Browsers",
+	"T1560":     "Archive Collected Data",
+	"T1562":     "Impair Defenses",
+	"T1562.001": "Impair Defenses: Disable or Modify Tools",
+	"T1564":     "Hide Artifacts",
+	"T1564.001": "Hide Artifacts: Hidden Files and Directories",
+	"T1566":     "Phishing",
+	"T1566.001": "Phishing: Spearphishing Attachment",
+	"T1568":     "Dynamic Resolution",
+	"T1569":     "System Services",
+	"T1570":     "Lateral Tool Transfer",
+	"T1571":     "Non-Standard Port",
+	"T1573":     "Encrypted Channel",
+	"T1574":     "Hijack Execution Flow",
+	"T1614":     "System Location Discovery",
+	"T1620":     "Reflective Code Loading",
+	"T1622":     "Debugger Evasion",
+}
+
+// GetATTCKTechniqueName returns the name of the technique, or of its parent technique along with the parent id
+// when the sub-technique is not known. The id is returned unchanged with an empty name if neither is known.
+func GetATTCKTechniqueName(technique string) (string, string) {
+	if name, ok := ATTCKTechniqueNames[technique]; ok {
+		return name, technique
+	}
+	if i := strings.Index(technique, "."); i > 0 {
+		if name, ok := ATTCKTechniqueNames[technique[:i]]; ok {
+			return name, technique[:i]
+		}
+	}
+	return "", technique
+}
+
+// MatchCatalogName returns the name of the catalog equal to the given one ignoring case,
+// the error suggests the closest names when there is none
+func MatchCatalogName(kind, name string, names []string) (string, error) {
+	for _, candidate := range names {
+		if strings.EqualFold(candidate, name) {
+			return candidate, nil
+		}
+	}
+	sort.Strings(names)
+	var suggestions []string
+	for _, candidate := range names {
+		if isSimilarName(candidate, name) {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	if len(suggestions) > 0 {
+		return "", fmt.Errorf("invalid %s '%s': did you mean %s? possible values are %s", kind, name, FormatStrSlice(suggestions), FormatStrSlice(names))
+	}
+	return "", fmt.Errorf("invalid %s '%s': possible values are %s", kind, name, FormatStrSlice(names))
+}
+
+// ParseCatalogNames splits the comma separated names and matches each of them against the catalog
+func ParseCatalogNames(kind, rawNames string, names []string) ([]string, error) {
+	var matched []string
+	for _, name := range strings.Split(rawNames, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		match, err := MatchCatalogName(kind, name, names)
+		if err != nil {
+			return nil, err
+		}
+		matched = append(matched, match)
+	}
+	return matched, nil
+}
+
+// isSimilarName tells whether one name contains the other or the name has about one typo every three letters
+func isSimilarName(candidate, name string) bool {
+	candidate, name = strings.ToLower(candidate), strings.ToLower(name)
+	if strings.Contains(candidate, name) || strings.Contains(name, candidate) {
+		return true
+	}
+	distance := getEditDistance(candidate, name)
+	return distance <= 1 || distance*3 <= len(name)
+}
+
+// getEditDistance returns the Levenshtein distance between both strings
+func getEditDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMatchCatalogName(t *testing.T) {
+	names := []string{"PE EXE", "PE DLL", "Scripts", "Archives", "Microsoft Office", "file", "url", "download"}
+	tests := []struct {
+		name  string
+		match string
+		err   string
+	}{
+		{name: "pe exe", match: "PE EXE"},
+		{name: "MICROSOFT OFFICE", match: "Microsoft Office"},
+		{name: "URL", match: "url"},
+		// one typo
+		{name: "Scrpts", err: `did you mean ["Scripts"]?`},
+		{name: "fil", err: `did you mean ["file"]?`},
+		// a part of the name
+		{name: "office", err: `did you mean ["Microsoft Office"]?`},
+		{name: "PE", err: `did you mean ["PE DLL","PE EXE"]?`},
+		{name: "archve", err: `did you mean ["Archives"]?`},
+		{name: "javascript", err: `possible values are`},
+		{name: "xyz", err: `possible values are`},
+	}
+	for _, test := range tests {
+		match, err := MatchCatalogName("extension", test.name, append([]string(nil), names...))
+		if test.err == "" {
+			if err != nil || match != test.match {
+				t.Errorf("%s: got '%s' %v, want '%s'", test.name, match, err, test.match)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: matched '%s'", test.name, match)
+			continue
+		}
+		prefix := fmt.Sprintf("invalid extension '%s': %s", test.name, test.err)
+		if !strings.HasPrefix(err.Error(), prefix) {
+			t.Errorf("%s: got %s, want %s", test.name, err, prefix)
+		}
+	}
+}
+
+func TestParseCatalogNames(t *testing.T) {
+	tests := []struct {
+		raw   string
+		names []string
+		err   bool
+	}{
+		{raw: "", names: nil},
+		{raw: " , ", names: nil},
+		{raw: "file", names: []string{"file"}},
+		{raw: "FILE, url ,download", names: []string{"file", "url", "download"}},
+		{raw: "file,uri", err: true},
+	}
+	for _, test := range tests {
+		names, err := ParseCatalogNames("run type", test.raw, []string{"file", "url", "download"})
+		if test.err != (err != nil) || fmt.Sprint(names) != fmt.Sprint(test.names) {
+			t.Errorf("'%s': got %v %v", test.raw, names, err)
+		}
+	}
+}
+
+func TestGetATTCKTechniqueName(t *testing.T) {
+	tests := []struct {
+		technique, name, id string
+	}{
+		{"T1055", "Process Injection", "T1055"},
+		{"T1055.012", "Process Injection: Process Hollowing", "T1055.012"},
+		{"T1055.004", "Process Injection", "T1055"},
+		{"T9999", "", "T9999"},
+	}
+	for _, test := range tests {
+		if name, id := GetATTCKTechniqueName(test.technique); name != test.name || id != test.id {
+			t.Errorf("GetATTCKTechniqueName(%s) = '%s', '%s', want '%s', '%s'", test.technique, name, id, test.name, test.id)
+		}
+	}
+}
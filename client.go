@@ -1,38 +1,81 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
 	"math/rand"
 	"net/http"
-	"strconv"
-	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	// handshake message
-	connectMsg                  = `["{\"msg\":\"connect\",\"version\":\"1\",\"support\":[\"1\",\"pre2\",\"pre1\"]}"]`
-	publicTasksCounterMsgFormat = `["{\"msg\":\"method\",\"method\":\"publicTasksCounter\",\"params\":[%s],\"id\":\"%s\"}"]`
-	publicTasksMsgFormat        = `["{\"msg\":\"sub\",\"id\":\"%s\",\"name\":\"publicTasks\",\"params\":[%d,%d,%s]}"]`
-	processesMsgFormat          = `["{\"msg\":\"sub\",\"id\":\"%s\",\"name\":\"process\",\"params\":[{\"taskID\":{\"$type\":\"oid\",\"$value\":\"%s\"},\"status\":100,\"important\":true}]}"]`
-	allIncidentsMsgFormat       = `["{\"msg\":\"sub\",\"id\":\"%s\",\"name\":\"allIncidents\",\"params\":[{\"$type\":\"oid\",\"$value\":\"%s\"}]}"]`
-	doneMsgFormat               = `{"msg":"ready","subs":["%s"]}`
-	pingMsg                     = `{"msg":"ping"}`
-	pongMsg                     = `["{\"msg\":\"pong\"}"]`
+	// collections the documents of each subscription are published to. Unlike the publicTasksCounter reply
+	// recorded in getNumOfTasks, no capture of these messages is kept here, so the names are
+	// unconfirmed: documents added to any other collection are warned about by the dispatcher.
+	tasksCollection        = "tasks"
+	processesCollection    = "processes"
+	incidentsCollection    = "incidents"
+	dnsQueriesCollection   = "dnsQueries"
+	connectionsCollection  = "connections"
+	httpRequestsCollection = "httpRequests"
+	taskExistsCollection   = "taskexists"
 
 	LettersDigits = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// the maximum number of tasks app.any.run returns per "publicTasks" subscription
+	MaxTasksPerPage = 50
+	// the number of new tasks an incremental crawl is limited to unless told otherwise
+	MaxNewTasksPerCrawl = 1000
 )
 
 type (
 	AppAnyClient struct {
-		conn      *websocket.Conn
 		appConfig *AppConfig
+		config    *AppAnyClientConfig
+		// index of the host in use
+		hostIndex int
+		// guards session which is replaced on reconnection
+		mu          sync.Mutex
+		session     *ddpSession
+		reconnectMu sync.Mutex
 	}
 	AppAnyClientConfig struct {
-		Endpoint  string
+		// hosts are tried in turn when a connection cannot be established or is lost
+		Hosts []string
+		// check /sockjs/info on the host before opening a websocket
+		ProbeInfo bool
 		ReqHeader http.Header
 		AppConfig *AppConfig
+		// number of reconnections allowed per operation
+		MaxRetries int
+		// the delay before the first reconnection, doubled on every retry up to MaxBackoff
+		MinBackoff time.Duration
+		MaxBackoff time.Duration
+		// the connection is considered lost when nothing, not even a heartbeat, is received for this long, 0 means never
+		ReadTimeout time.Duration
+		// a subscription or method call not answered for this long breaks the session, so that it is retried
+		// on a new connection even though heartbeats keep arriving, 0 means never
+		ReqTimeout time.Duration
+		// limits subscriptions and method calls of this client, nil means unlimited
+		RateLimiter *RateLimiter
+		// limits subscriptions and method calls of all clients sharing it, nil means unlimited
+		GlobalRateLimiter *RateLimiter
+		// receives the added, changed and removed documents of all subscriptions, nil means ignored.
+		// Subscriptions are stopped once ready, so only changes merged before that are received.
+		// It is called by the reader goroutine and must not block.
+		OnChange func(event *DocumentEvent)
+	}
+	// taskFilter is the parameter of the subscriptions to the tabs of a task
+	taskFilter struct {
+		TaskID ObjectID `json:"taskID"`
+	}
+	processFilter struct {
+		TaskID    ObjectID `json:"taskID"`
+		Status    int      `json:"status"`
+		Important bool     `json:"important"`
 	}
 	TaskParams struct {
 		IsPublic    bool     `json:"isPublic"`
@@ -52,46 +95,89 @@ type (
 )
 
 func NewAppAnyClient(config *AppAnyClientConfig) (*AppAnyClient, error) {
-	conn, _, err := websocket.DefaultDialer.Dial(config.Endpoint, config.ReqHeader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create a new socket client connection: %s", err)
-	}
-	// RECEIVED: o
-	// RECEIVED: a["{\"server_id\":\"0\"}"]
-	conn.ReadMessage()
-	conn.ReadMessage()
-	return &AppAnyClient{
-		conn:      conn,
+	if len(config.Hosts) == 0 {
+		return nil, fmt.Errorf("no host configured")
+	}
+	client := &AppAnyClient{
 		appConfig: config.AppConfig,
-	}, nil
+		config:    config,
+		hostIndex: rand.Intn(len(config.Hosts)),
+	}
+	var err error
+	for range config.Hosts { // fall back across hosts
+		if client.session, err = client.dial(); err == nil {
+			return client, nil
+		}
+		client.hostIndex = (client.hostIndex + 1) % len(config.Hosts)
+	}
+	return nil, err
 }
 
-func (client *AppAnyClient) getPublicTasksCounterMsg(id string) string {
-	return fmt.Sprintf(publicTasksCounterMsgFormat, client.appConfig.ToTaskParamsJsonQuoted(), id)
+// dial opens a new SockJS session on the current host, the caller does the handshake before using it
+func (client *AppAnyClient) dial() (*ddpSession, error) {
+	host := client.config.Hosts[client.hostIndex]
+	// Clone of a nil header is nil
+	reqHeader := make(http.Header)
+	if client.config.ReqHeader != nil {
+		reqHeader = client.config.ReqHeader.Clone()
+	}
+	reqHeader.Set("Origin", "https://"+host)
+	if client.config.ProbeInfo {
+		if _, err := ProbeSockJSInfo(host, reqHeader); err != nil {
+			return nil, fmt.Errorf("failed to probe SockJS info of '%s': %s", host, err)
+		}
+	}
+	endpoint := NewSockJSEndpoint(host)
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, reqHeader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a new socket client connection to '%s': %s", endpoint, err)
+	}
+	return newDDPSession(conn, client.config.ReadTimeout, client.config.OnChange), nil
 }
 
-func (client *AppAnyClient) getPublicTasksMsg(id string, taskCount, startIndex int) string {
-	return fmt.Sprintf(publicTasksMsgFormat, id, taskCount, startIndex, client.appConfig.ToTaskParamsJsonQuoted())
+func (client *AppAnyClient) getPublicTasksCounterMsg(id string) *ddpMethod {
+	return newDDPMethod(id, "publicTasksCounter", client.appConfig.ToTaskParams())
 }
 
-func (client *AppAnyClient) getProcessesMsg(id string, taskId string) string {
-	return fmt.Sprintf(processesMsgFormat, id, taskId)
+func (client *AppAnyClient) getPublicTasksMsg(id string, taskCount, startIndex int) *ddpSub {
+	return newDDPSub(id, "publicTasks", taskCount, startIndex, client.appConfig.ToTaskParams())
 }
 
-func (client *AppAnyClient) getAllIncidentsMsg(id string, taskId string) string {
-	return fmt.Sprintf(allIncidentsMsgFormat, id, taskId)
+func (client *AppAnyClient) getProcessesMsg(id string, taskId string) *ddpSub {
+	return newDDPSub(id, "process", &processFilter{
+		TaskID:    NewObjectID(taskId),
+		Status:    100,
+		Important: true,
+	})
 }
 
-func (client *AppAnyClient) getDoneMsg(id string) string {
-	return fmt.Sprintf(doneMsgFormat, id)
+func (client *AppAnyClient) getAllIncidentsMsg(id string, taskId string) *ddpSub {
+	return newDDPSub(id, "allIncidents", NewObjectID(taskId))
 }
 
-func (client *AppAnyClient) recvMessageAndAssert(expectedMsg string) (bool, error) {
-	msg, err := client.recvMessage()
-	if err != nil {
-		return false, err
-	}
-	return msg == expectedMsg, nil
+func (client *AppAnyClient) getDNSQueriesMsg(id string, taskId string) *ddpSub {
+	return newDDPSub(id, "dnsQueries", &taskFilter{TaskID: NewObjectID(taskId)})
+}
+
+func (client *AppAnyClient) getConnectionsMsg(id string, taskId string) *ddpSub {
+	return newDDPSub(id, "connections", &taskFilter{TaskID: NewObjectID(taskId)})
+}
+
+func (client *AppAnyClient) getHttpRequestsMsg(id string, taskId string) *ddpSub {
+	return newDDPSub(id, "httpRequests", &taskFilter{TaskID: NewObjectID(taskId)})
+}
+
+func (client *AppAnyClient) getTaskExistsMsg(id string, taskUuid string) *ddpSub {
+	return newDDPSub(id, "taskexists", taskUuid)
+}
+
+func (client *AppAnyClient) getSingleTaskMsg(id string, taskId string) *ddpSub {
+	return newDDPSub(id, "singleTask", NewObjectID(taskId), true)
+}
+
+// isDocOfTask tells documents of the task apart from those added for concurrent subscriptions on the same collection
+func isDocOfTask(taskId string, task *RawTask) bool {
+	return taskId == "" || taskId == task.ID
 }
 
 func generateRandStr(n int) string {
@@ -102,132 +188,270 @@ func generateRandStr(n int) string {
 	return string(randStr)
 }
 
-func (client *AppAnyClient) recvMessage() (string, error) {
-	_, buffer, err := client.conn.ReadMessage()
+func (client *AppAnyClient) getSession() *ddpSession {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.session
+}
+
+// throttle waits for both rate limiters before a request is sent
+func (client *AppAnyClient) throttle(ctx context.Context) error {
+	globalWaited, err := client.config.GlobalRateLimiter.Wait(ctx)
 	if err != nil {
-		return "", fmt.Errorf("in ReadMessage: %s", err)
+		return err
 	}
-	if len(buffer) > 2 && buffer[0] == 'a' && buffer[1] == '[' { // message format: a[escaped_json]
-		msg, err := strconv.Unquote(string(buffer[2 : len(buffer)-1]))
-		if err != nil {
-			return "", fmt.Errorf("in strconv.Unquote: %s", err)
-		}
-		return msg, nil
+	waited, err := client.config.RateLimiter.Wait(ctx)
+	if err != nil {
+		return err
 	}
-	if string(buffer) == pingMsg {
-		if err := client.sendMessage(pongMsg); err != nil {
-			return "", fmt.Errorf("failed to send pong msg: %s", err)
-		}
-		return client.recvMessage()
+	if waited += globalWaited; waited > 0 {
+		log.Debug().Msgf("rate limited for %s", waited)
 	}
-	return string(buffer), nil
+	return nil
 }
 
-func (client *AppAnyClient) sendMessage(msg string) error {
-	if err := client.conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
-		return fmt.Errorf("in WriteMessage: %s", err)
+// subscribe runs the subscription on the current session, see ddpSession.subscribe
+func (client *AppAnyClient) subscribe(ctx context.Context, collection string, sub *ddpSub) ([]string, error) {
+	if err := client.throttle(ctx); err != nil {
+		return nil, err
 	}
-	return nil
+	session := client.getSession()
+	reqCtx, cancel := client.withReqTimeout(ctx)
+	defer cancel()
+	docs, err := session.subscribe(reqCtx, collection, sub)
+	if err != nil && ctx.Err() == nil && reqCtx.Err() != nil {
+		err = fmt.Errorf("subscription '%s' not ready after %s", sub.Name, client.config.ReqTimeout)
+		session.fail(err)
+	}
+	return docs, err
 }
 
-func (client *AppAnyClient) Connect() error {
-	if err := client.sendMessage(connectMsg); err != nil {
-		return fmt.Errorf("in sendMessage: %s", err)
+// call runs the method on the current session, see ddpSession.call
+func (client *AppAnyClient) call(ctx context.Context, method *ddpMethod) (*ddpResult, error) {
+	if err := client.throttle(ctx); err != nil {
+		return nil, err
 	}
-	msg, err := client.recvMessage()
-	if err != nil {
-		return fmt.Errorf("in recvMessage: %s", err)
+	session := client.getSession()
+	reqCtx, cancel := client.withReqTimeout(ctx)
+	defer cancel()
+	reply, err := session.call(reqCtx, method)
+	if err != nil && ctx.Err() == nil && reqCtx.Err() != nil {
+		err = fmt.Errorf("method '%s' not answered after %s", method.Method, client.config.ReqTimeout)
+		session.fail(err)
 	}
-	if !strings.Contains(msg, "connected") {
-		return fmt.Errorf("unexpected received msg: '%s'", msg)
+	return reply, err
+}
+
+// withReqTimeout bounds a single request by the configured timeout
+func (client *AppAnyClient) withReqTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if client.config.ReqTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	return nil
+	return context.WithTimeout(ctx, client.config.ReqTimeout)
+}
+
+// Connect does the DDP handshake on the session opened by NewAppAnyClient
+func (client *AppAnyClient) Connect() error {
+	return client.getSession().connect()
 }
 
 func (client *AppAnyClient) GetNumOfTasks() (uint, error) {
-	id := strconv.FormatInt(rand.Int63n(64), 10)
-	msg := client.getPublicTasksCounterMsg(id)
-	if err := client.sendMessage(msg); err != nil {
-		return 0, fmt.Errorf("in sendMessage: %s", err)
-	}
-	// RECEIVED: a["{\"msg\":\"updated\",\"methods\":[\"5\"]}"]
-	if _, err := client.recvMessage(); err != nil {
-		return 0, fmt.Errorf("in recvMessage: %s", err)
-	}
+	return client.GetNumOfTasksCtx(context.Background())
+}
+
+// GetNumOfTasksCtx is like GetNumOfTasks but gives up when the context is done
+func (client *AppAnyClient) GetNumOfTasksCtx(ctx context.Context) (uint, error) {
+	var result uint
+	err := client.withRetry(ctx, func() (err error) {
+		result, err = client.getNumOfTasks(ctx)
+		return
+	})
+	return result, err
+}
+
+func (client *AppAnyClient) getNumOfTasks(ctx context.Context) (uint, error) {
+	id := generateRandStr(len("xW3dTq9LmZcR5vKbN"))
 	// RECEIVED: a["{\"msg\":\"result\",\"id\":\"5\",\"result\":{\"count\":2089989}}"]
-	buffer, err := client.recvMessage()
+	reply, err := client.call(ctx, client.getPublicTasksCounterMsg(id))
 	if err != nil {
-		return 0, fmt.Errorf("in recvMessage: %s", err)
+		return 0, fmt.Errorf("in call: %w", err)
 	}
 	result := new(PublicTasksCounterResult)
-	if err := json.Unmarshal([]byte(buffer), &result); err != nil {
+	if err := json.Unmarshal(reply.Result, &result); err != nil {
 		return 0, fmt.Errorf("in Unmarshal: %s", err)
 	}
-	if id != result.ID {
-		return 0, fmt.Errorf("corrupted recieved data: mismatched id")
+	return result.Count, nil
+}
+
+// lookupTask resolves the public task uuid to the internal object id, it returns nil if the task does not exist
+func (client *AppAnyClient) lookupTask(ctx context.Context, taskUuid string) (*TaskExistsResult, error) {
+	var result *TaskExistsResult
+	id := generateRandStr(len("L6La59ezwZEf9qP2F"))
+	docs, err := client.subscribe(ctx, taskExistsCollection, client.getTaskExistsMsg(id, taskUuid))
+	if err != nil {
+		return nil, fmt.Errorf("in subscribe: %w", err)
+	}
+	for _, doc := range docs { // receive the existence result
+		var candidate *TaskExistsResult
+		if err := json.Unmarshal([]byte(doc), &candidate); err != nil {
+			return nil, fmt.Errorf("in Unmarshal: %s", err)
+		}
+		if candidate.Fields.TaskID == "" || candidate.Fields.TaskID == taskUuid {
+			result = candidate
+		}
+	}
+	if result == nil || result.Fields.TaskObjectID.Value == "" {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// TaskExists checks whether the task identified by the public uuid (as in https://app.any.run/tasks/<uuid>) exists
+func (client *AppAnyClient) TaskExists(taskUuid string) (bool, error) {
+	return client.TaskExistsCtx(context.Background(), taskUuid)
+}
+
+// TaskExistsCtx is like TaskExists but gives up when the context is done
+func (client *AppAnyClient) TaskExistsCtx(ctx context.Context, taskUuid string) (bool, error) {
+	var result bool
+	err := client.withRetry(ctx, func() (err error) {
+		result, err = client.taskExists(ctx, taskUuid)
+		return
+	})
+	return result, err
+}
+
+func (client *AppAnyClient) taskExists(ctx context.Context, taskUuid string) (bool, error) {
+	result, err := client.lookupTask(ctx, taskUuid)
+	if err != nil {
+		return false, err
+	}
+	return result != nil, nil
+}
+
+// GetTaskByUUID returns the task identified by the public uuid (as in https://app.any.run/tasks/<uuid>)
+func (client *AppAnyClient) GetTaskByUUID(taskUuid string) (*RawTask, error) {
+	return client.GetTaskByUUIDCtx(context.Background(), taskUuid)
+}
+
+// GetTaskByUUIDCtx is like GetTaskByUUID but gives up when the context is done
+func (client *AppAnyClient) GetTaskByUUIDCtx(ctx context.Context, taskUuid string) (*RawTask, error) {
+	var result *RawTask
+	err := client.withRetry(ctx, func() (err error) {
+		result, err = client.getTaskByUUID(ctx, taskUuid)
+		return
+	})
+	return result, err
+}
+
+func (client *AppAnyClient) getTaskByUUID(ctx context.Context, taskUuid string) (*RawTask, error) {
+	result, err := client.lookupTask(ctx, taskUuid)
+	if err != nil {
+		return nil, fmt.Errorf("in lookupTask: %w", err)
+	}
+	if result == nil {
+		return nil, fmt.Errorf("task '%s' not found", taskUuid)
+	}
+	taskId := result.Fields.TaskObjectID.Value
+
+	var task *RawTask
+	id := generateRandStr(len("mkdKdJqprjPj98Z2e"))
+	docs, err := client.subscribe(ctx, tasksCollection, client.getSingleTaskMsg(id, taskId))
+	if err != nil {
+		return nil, fmt.Errorf("in subscribe: %w", err)
+	}
+	for _, doc := range docs { // receive the task, other related documents are ignored
+		var candidate *RawTask
+		if err := json.Unmarshal([]byte(doc), &candidate); err != nil {
+			return nil, fmt.Errorf("in Unmarshal: %s", err)
+		}
+		if candidate.ID == taskId {
+			task = candidate
+		}
 	}
-	return result.Result.Count, nil
+	if task == nil {
+		return nil, fmt.Errorf("task '%s' not received", taskUuid)
+	}
+	return task, nil
 }
 
-// GetProcesses returns a list of task information as "public tasks" tab
+// GetTasks returns a list of task information as "public tasks" tab
 func (client *AppAnyClient) GetTasks(numOfTasks, startIndex int) ([]*RawTask, error) {
+	return client.GetTasksCtx(context.Background(), numOfTasks, startIndex)
+}
+
+// GetTasksCtx is like GetTasks but gives up when the context is done
+func (client *AppAnyClient) GetTasksCtx(ctx context.Context, numOfTasks, startIndex int) ([]*RawTask, error) {
 	tasks := make([]*RawTask, 0)
 	for numOfTasks > 0 {
-		id := generateRandStr(len("DrDA7Qycqa8w9aLF9"))
 		var taskCount int
-		if numOfTasks >= 50 {
-			taskCount = 50
+		if numOfTasks >= MaxTasksPerPage {
+			taskCount = MaxTasksPerPage
 		} else {
 			taskCount = numOfTasks
 		}
-		msg := client.getPublicTasksMsg(id, taskCount, startIndex)
-		doneMsg := client.getDoneMsg(id)
-
-		if err := client.sendMessage(msg); err != nil {
-			return nil, fmt.Errorf("in sendMessage: %s", err)
-		}
-		for { // receive tasks
-			var task *RawTask
-			buffer, err := client.recvMessage()
-			if err != nil {
-				return nil, fmt.Errorf("in recvMessage: %s", err)
-			}
-			if buffer == doneMsg {
-				break
-			}
-			if err := json.Unmarshal([]byte(buffer), &task); err != nil {
-				return nil, fmt.Errorf("in Unmarshal: %s", err)
-			}
-			tasks = append(tasks, task)
+		var page []*RawTask
+		err := client.withRetry(ctx, func() (err error) {
+			page, err = client.getTasksPage(ctx, taskCount, startIndex)
+			return
+		})
+		if err != nil {
+			return nil, err
 		}
+		tasks = append(tasks, page...)
 		numOfTasks -= taskCount
 		startIndex += taskCount
 	}
 	return tasks, nil
 }
 
+func (client *AppAnyClient) getTasksPage(ctx context.Context, taskCount, startIndex int) ([]*RawTask, error) {
+	tasks := make([]*RawTask, 0)
+	id := generateRandStr(len("DrDA7Qycqa8w9aLF9"))
+	docs, err := client.subscribe(ctx, tasksCollection, client.getPublicTasksMsg(id, taskCount, startIndex))
+	if err != nil {
+		return nil, fmt.Errorf("in subscribe: %w", err)
+	}
+	for _, doc := range docs { // receive tasks
+		var task *RawTask
+		if err := json.Unmarshal([]byte(doc), &task); err != nil {
+			return nil, fmt.Errorf("in Unmarshal: %s", err)
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
 // GetProcesses returns a list of processes as "processes" tab
 func (client *AppAnyClient) GetProcesses(task *RawTask) ([]*RawProcess, error) {
+	return client.GetProcessesCtx(context.Background(), task)
+}
+
+// GetProcessesCtx is like GetProcesses but gives up when the context is done
+func (client *AppAnyClient) GetProcessesCtx(ctx context.Context, task *RawTask) ([]*RawProcess, error) {
+	var result []*RawProcess
+	err := client.withRetry(ctx, func() (err error) {
+		result, err = client.getProcesses(ctx, task)
+		return
+	})
+	return result, err
+}
+
+func (client *AppAnyClient) getProcesses(ctx context.Context, task *RawTask) ([]*RawProcess, error) {
 	processes := make([]*RawProcess, 0)
 	id := generateRandStr(len("E8ZWdmyNwRD3XBvcc"))
-	msg := client.getProcessesMsg(id, task.ID)
-	doneMsg := client.getDoneMsg(id)
-
-	if err := client.sendMessage(msg); err != nil {
-		return nil, fmt.Errorf("in sendMessage: %s", err)
+	docs, err := client.subscribe(ctx, processesCollection, client.getProcessesMsg(id, task.ID))
+	if err != nil {
+		return nil, fmt.Errorf("in subscribe: %w", err)
 	}
-	for { // receive processes
+	for _, doc := range docs { // receive processes
 		var process *RawProcess
-		buffer, err := client.recvMessage()
-		if err != nil {
-			return nil, fmt.Errorf("in recvMessage: %s", err)
-		}
-		if buffer == doneMsg {
-			break
-		}
-		if err := json.Unmarshal([]byte(buffer), &process); err != nil {
+		if err := json.Unmarshal([]byte(doc), &process); err != nil {
 			return nil, fmt.Errorf("in Unmarshal: %s", err)
 		}
+		if !isDocOfTask(process.Fields.Task.Value, task) {
+			continue
+		}
 		processes = append(processes, process)
 	}
 	return processes, nil
@@ -235,42 +459,140 @@ func (client *AppAnyClient) GetProcesses(task *RawTask) ([]*RawProcess, error) {
 
 // GetIncidents returns a list of MITRE ATT&CK  as "ATT&CK" tab
 func (client *AppAnyClient) GetIncidents(task *RawTask) ([]*RawIncident, error) {
+	return client.GetIncidentsCtx(context.Background(), task)
+}
+
+// GetIncidentsCtx is like GetIncidents but gives up when the context is done
+func (client *AppAnyClient) GetIncidentsCtx(ctx context.Context, task *RawTask) ([]*RawIncident, error) {
+	var result []*RawIncident
+	err := client.withRetry(ctx, func() (err error) {
+		result, err = client.getIncidents(ctx, task)
+		return
+	})
+	return result, err
+}
+
+func (client *AppAnyClient) getIncidents(ctx context.Context, task *RawTask) ([]*RawIncident, error) {
 	incidents := make([]*RawIncident, 0)
 	id := generateRandStr(len("4aYatF54JSoCNG94C"))
-	msg := client.getAllIncidentsMsg(id, task.ID)
-	doneMsg := client.getDoneMsg(id)
-
-	if err := client.sendMessage(msg); err != nil {
-		return nil, fmt.Errorf("in sendMessage: %s", err)
+	docs, err := client.subscribe(ctx, incidentsCollection, client.getAllIncidentsMsg(id, task.ID))
+	if err != nil {
+		return nil, fmt.Errorf("in subscribe: %w", err)
 	}
-	for { // receive incidents
+	for _, doc := range docs { // receive incidents
 		var incident *RawIncident
-		buffer, err := client.recvMessage()
-		if err != nil {
-			return nil, fmt.Errorf("in recvMessage: %s", err)
-		}
-		if buffer == doneMsg {
-			break
-		}
-		if err := json.Unmarshal([]byte(buffer), &incident); err != nil {
+		if err := json.Unmarshal([]byte(doc), &incident); err != nil {
 			return nil, fmt.Errorf("in Unmarshal: %s", err)
 		}
+		if !isDocOfTask(incident.Fields.Task.Value, task) {
+			continue
+		}
 		incidents = append(incidents, incident)
 	}
 	return incidents, nil
 }
 
-// GetDNSQueries returns a list of DSN queries as "DNS Queries" tab
-func (client *AppAnyClient) GetDNSQueries(task *RawTask) ([]interface{}, error) {
-	return nil, nil
+// GetDNSQueries returns a list of DNS queries as "DNS Queries" tab
+func (client *AppAnyClient) GetDNSQueries(task *RawTask) ([]*RawDNSQuery, error) {
+	return client.GetDNSQueriesCtx(context.Background(), task)
+}
+
+// GetDNSQueriesCtx is like GetDNSQueries but gives up when the context is done
+func (client *AppAnyClient) GetDNSQueriesCtx(ctx context.Context, task *RawTask) ([]*RawDNSQuery, error) {
+	var result []*RawDNSQuery
+	err := client.withRetry(ctx, func() (err error) {
+		result, err = client.getDNSQueries(ctx, task)
+		return
+	})
+	return result, err
+}
+
+func (client *AppAnyClient) getDNSQueries(ctx context.Context, task *RawTask) ([]*RawDNSQuery, error) {
+	queries := make([]*RawDNSQuery, 0)
+	id := generateRandStr(len("Xq5hTfBvN8cLrW2kd"))
+	docs, err := client.subscribe(ctx, dnsQueriesCollection, client.getDNSQueriesMsg(id, task.ID))
+	if err != nil {
+		return nil, fmt.Errorf("in subscribe: %w", err)
+	}
+	for _, doc := range docs { // receive dns queries
+		var query *RawDNSQuery
+		if err := json.Unmarshal([]byte(doc), &query); err != nil {
+			return nil, fmt.Errorf("in Unmarshal: %s", err)
+		}
+		if !isDocOfTask(query.Fields.Task.Value, task) {
+			continue
+		}
+		queries = append(queries, query)
+	}
+	return queries, nil
 }
 
 // GetNetworkConnections returns a list of network connections as "Connections" tab
-func (client *AppAnyClient) GetNetworkConnections(task *RawTask) ([]interface{}, error) {
-	return nil, nil
+func (client *AppAnyClient) GetNetworkConnections(task *RawTask) ([]*RawConnection, error) {
+	return client.GetNetworkConnectionsCtx(context.Background(), task)
+}
+
+// GetNetworkConnectionsCtx is like GetNetworkConnections but gives up when the context is done
+func (client *AppAnyClient) GetNetworkConnectionsCtx(ctx context.Context, task *RawTask) ([]*RawConnection, error) {
+	var result []*RawConnection
+	err := client.withRetry(ctx, func() (err error) {
+		result, err = client.getNetworkConnections(ctx, task)
+		return
+	})
+	return result, err
+}
+
+func (client *AppAnyClient) getNetworkConnections(ctx context.Context, task *RawTask) ([]*RawConnection, error) {
+	connections := make([]*RawConnection, 0)
+	id := generateRandStr(len("pK7vRzJ3mYtW9sDgH"))
+	docs, err := client.subscribe(ctx, connectionsCollection, client.getConnectionsMsg(id, task.ID))
+	if err != nil {
+		return nil, fmt.Errorf("in subscribe: %w", err)
+	}
+	for _, doc := range docs { // receive connections
+		var connection *RawConnection
+		if err := json.Unmarshal([]byte(doc), &connection); err != nil {
+			return nil, fmt.Errorf("in Unmarshal: %s", err)
+		}
+		if !isDocOfTask(connection.Fields.Task.Value, task) {
+			continue
+		}
+		connections = append(connections, connection)
+	}
+	return connections, nil
 }
 
 // GetHttpRequests returns a list of HTTP requests as "HTTP Requests" tab
-func (client *AppAnyClient) GetHttpRequests(task *RawTask) ([]interface{}, error) {
-	return nil, nil
+func (client *AppAnyClient) GetHttpRequests(task *RawTask) ([]*RawHTTPRequest, error) {
+	return client.GetHttpRequestsCtx(context.Background(), task)
+}
+
+// GetHttpRequestsCtx is like GetHttpRequests but gives up when the context is done
+func (client *AppAnyClient) GetHttpRequestsCtx(ctx context.Context, task *RawTask) ([]*RawHTTPRequest, error) {
+	var result []*RawHTTPRequest
+	err := client.withRetry(ctx, func() (err error) {
+		result, err = client.getHttpRequests(ctx, task)
+		return
+	})
+	return result, err
+}
+
+func (client *AppAnyClient) getHttpRequests(ctx context.Context, task *RawTask) ([]*RawHTTPRequest, error) {
+	requests := make([]*RawHTTPRequest, 0)
+	id := generateRandStr(len("Tn4bGwQ8eLsZc6yVu"))
+	docs, err := client.subscribe(ctx, httpRequestsCollection, client.getHttpRequestsMsg(id, task.ID))
+	if err != nil {
+		return nil, fmt.Errorf("in subscribe: %w", err)
+	}
+	for _, doc := range docs { // receive http requests
+		var request *RawHTTPRequest
+		if err := json.Unmarshal([]byte(doc), &request); err != nil {
+			return nil, fmt.Errorf("in Unmarshal: %s", err)
+		}
+		if !isDocOfTask(request.Fields.Task.Value, task) {
+			continue
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConnect(t *testing.T) {
+	server := &fakeServer{}
+	newFakeClient(t, server, &AppConfig{})
+	// the ping shares the frame of "connected" and must be dispatched too
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		server.mu.Lock()
+		numOfPongs := server.numOfPongs
+		server.mu.Unlock()
+		if numOfPongs == 1 {
+			return
+		}
+	}
+	t.Fatal("the ping sent along with the handshake reply is not answered")
+}
+
+func TestDialWithoutHeader(t *testing.T) {
+	server := &fakeServer{}
+	httpServer := httptest.NewTLSServer(server)
+	defer httpServer.Close()
+	host := strings.TrimPrefix(httpServer.URL, "https://")
+	websocket.DefaultDialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	client, err := NewAppAnyClient(&AppAnyClientConfig{
+		Hosts:     []string{host},
+		AppConfig: &AppConfig{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.origin != "https://"+host {
+		t.Errorf("dialed with origin '%s', want 'https://%s'", server.origin, host)
+	}
+	client.getSession().conn.Close()
+}
+
+func TestReconnect(t *testing.T) {
+	for _, batched := range []bool{false, true} {
+		server := &fakeServer{numOfTasks: 120, batched: batched, dropOnSub: 2}
+		client := newFakeClient(t, server, &AppConfig{})
+		tasks, err := client.GetTasks(120, 0)
+		if err != nil {
+			t.Fatalf("batched %t: %s", batched, err)
+		}
+		if len(tasks) != 120 {
+			t.Errorf("batched %t: got %d tasks, want 120", batched, len(tasks))
+		}
+		if _, err := client.GetNumOfTasks(); err != nil {
+			t.Errorf("batched %t: %s", batched, err)
+		}
+	}
+}
+
+func TestReqTimeout(t *testing.T) {
+	server := &fakeServer{numOfTasks: 1, stuck: map[string]int{"process": 1}}
+	client := newFakeClient(t, server, &AppConfig{})
+	client.config.ReqTimeout = 50 * time.Millisecond
+	tasks, err := client.GetTasks(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stuck subscription is retried on a new connection
+	processes, err := client.GetProcesses(tasks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processes) != 2 {
+		t.Errorf("got %d processes, want 2", len(processes))
+	}
+}
+
+func TestGetDNSQueries(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{}, &AppConfig{})
+	queries, err := client.GetDNSQueries(&RawTask{ID: "oid-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 {
+		t.Fatalf("got %d dns queries, want 1", len(queries))
+	}
+	fields := queries[0].Fields
+	if fields.Task.Value != "oid-1" || fields.Domain != "evil.example" || fmt.Sprint(fields.IPs) != "[203.0.113.1 203.0.113.2]" ||
+		fields.Reputation != "malicious" || fields.Times.Request.Date != 1600000000000 || fields.Times.Response.Date != 1600000000050 {
+		t.Errorf("unexpected dns query %+v", fields)
+	}
+}
+
+func TestGetNetworkConnections(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{}, &AppConfig{})
+	connections, err := client.GetNetworkConnections(&RawTask{ID: "oid-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connections) != 1 {
+		t.Fatalf("got %d connections, want 1", len(connections))
+	}
+	fields := connections[0].Fields
+	if fields.Task.Value != "oid-1" || fields.ProcessOID.Value != "oid-1-p0" || fields.Pid != 100 || fields.Protocol != "tcp" ||
+		fields.SrcIP != "192.168.100.10" || fields.SrcPort != 49152 || fields.DstIP != "203.0.113.1" || fields.DstPort != 443 ||
+		fields.Country != "NL" || fields.ASN != "AS64496" || fields.Reputation != "suspicious" ||
+		fields.Times.Created.Date != 1600000000100 || fields.Times.Closed.Date != 1600000000900 {
+		t.Errorf("unexpected connection %+v", fields)
+	}
+}
+
+func TestGetHttpRequests(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{}, &AppConfig{})
+	requests, err := client.GetHttpRequests(&RawTask{ID: "oid-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("got %d http requests, want 1", len(requests))
+	}
+	fields := requests[0].Fields
+	if fields.Task.Value != "oid-1" || fields.ProcessOID.Value != "oid-1-p0" || fields.Pid != 100 || fields.Method != "POST" ||
+		fields.URL != "http://evil.example/gate.php" || fields.Host != "evil.example" || fields.IP != "203.0.113.1" ||
+		fields.Port != 80 || fields.UserAgent != "Mozilla/4.0" || fields.Reputation != "malicious" ||
+		fields.Times.Request.Date != 1600000000200 || fields.Times.Response.Date != 1600000000300 {
+		t.Errorf("unexpected http request %+v", fields)
+	}
+	if response := fields.Response; response.Status != 200 || response.ContentType != "text/html" || response.Size != 1024 {
+		t.Errorf("unexpected http response %+v", response)
+	}
+}
+
+func TestGetTaskByUUID(t *testing.T) {
+	client := newFakeClient(t, &fakeServer{numOfTasks: 10}, &AppConfig{})
+	task, err := client.GetTaskByUUID("task-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ID != "oid-7" || task.Fields.UUID != "task-7" || task.Fields.Date.Date != 1007 || fmt.Sprint(task.Fields.Tags) != "[emotet]" {
+		t.Errorf("unexpected task %s %+v", task.ID, task.Fields)
+	}
+	for taskUuid, want := range map[string]bool{"task-7": true, "task-10": false} {
+		if exists, err := client.TaskExists(taskUuid); err != nil || exists != want {
+			t.Errorf("task '%s' exists %t (%v), want %t", taskUuid, exists, err, want)
+		}
+	}
+	if _, err := client.GetTaskByUUID("task-10"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got error '%v', want the task not found", err)
+	}
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/prometheus/common/log"
 	"github.com/spf13/viper"
-	"strconv"
+	"net"
+	"regexp"
 	"strings"
+	"time"
 )
 
 const (
@@ -14,18 +15,97 @@ const (
 	DefTaskIsSignificant = false
 	DefTaskExtensions    = ""
 	DefTaskDetections    = ""
+	DefTaskHash          = ""
+	DefTaskRuntypes      = ""
+	DefTaskIP            = ""
+	DefTaskDomain        = ""
+	DefTaskFileHash      = ""
+	DefTaskMitreID       = ""
+	DefTaskSid           = 0
+	DefTaskSkip          = 0
 	DefExportProcesses   = true
 	DefExportATTCKMatrix = true
+	DefExportDNSQueries  = false
+	DefExportConnections = false
+	DefExportHTTPReqs    = false
+	DefExportDir         = "tasks"
+	DefWatchInterval     = time.Minute
+	DefConnHosts         = "app.any.run"
+	DefConnProbeInfo     = true
+	DefConnMaxRetries    = 5
+	DefConnMinBackoff    = time.Second
+	DefConnMaxBackoff    = time.Minute
+	DefConnReadTimeout   = time.Minute
+	DefConnReqTimeout    = 2 * time.Minute
+	DefRateLimitRPS      = 5.0
+	DefRateLimitBurst    = 10
+	DefRateLimitJitter   = time.Duration(0)
+	DefECSIndex          = "anyrun"
+	DefECSEndpoint       = ""
+	DefECSBatchSize      = 500
+	DefECSFlushInterval  = 30 * time.Second
+	DefMISPURL           = ""
+	DefMISPDistribution  = 0
+	DefMISPInsecure      = false
+	DefCSVDelimiter      = ","
 )
 
+// mitreIDPattern matches ATT&CK technique ids, e.g. T1055 or T1055.012
+var mitreIDPattern = regexp.MustCompile(`^T\d{4}(\.\d{3})?$`)
+
 type AppConfig struct {
 	taskTag           string
 	taskIsSignificant bool
 	taskExtensions    []string
 	taskDetections    []int
+	taskHash          string
+	taskRuntypes      []string
+	taskIP            string
+	taskDomain        string
+	taskFileHash      string
+	taskMitreID       string
+	taskSid           int
+	taskSkip          int
+
+	exportProcesses    bool
+	exportATTCKMatrix  bool
+	exportDNSQueries   bool
+	exportConnections  bool
+	exportHTTPRequests bool
+	exportDir          string
+
+	watchInterval time.Duration
+
+	connHosts       []string
+	connProbeInfo   bool
+	connMaxRetries  int
+	connMinBackoff  time.Duration
+	connMaxBackoff  time.Duration
+	connReadTimeout time.Duration
+	connReqTimeout  time.Duration
+
+	rateLimitRPS         float64
+	rateLimitBurst       int
+	rateLimitGlobalRPS   float64
+	rateLimitGlobalBurst int
+	rateLimitJitter      time.Duration
+
+	ecsIndex         string
+	ecsEndpoint      string
+	ecsUsername      string
+	ecsPassword      string
+	ecsBatchSize     int
+	ecsFlushInterval time.Duration
 
-	exportProcesses   bool
-	exportATTCKMatrix bool
+	mispURL          string
+	mispKey          string
+	mispDistribution int
+	mispInsecure     bool
+
+	csvDelimiter       rune
+	csvTaskColumns     []string
+	csvProcessColumns  []string
+	csvIncidentColumns []string
 }
 
 func ReadAppConfig(configFilePath string) (*AppConfig, error) {
@@ -43,48 +123,233 @@ func ReadAppConfig(configFilePath string) (*AppConfig, error) {
 	viper.SetDefault("public_tasks.significant", DefTaskIsSignificant)
 	viper.SetDefault("public_tasks.extensions", DefTaskExtensions)
 	viper.SetDefault("public_tasks.detections", DefTaskDetections)
+	viper.SetDefault("public_tasks.hash", DefTaskHash)
+	viper.SetDefault("public_tasks.runtype", DefTaskRuntypes)
+	viper.SetDefault("public_tasks.ip", DefTaskIP)
+	viper.SetDefault("public_tasks.domain", DefTaskDomain)
+	viper.SetDefault("public_tasks.file_hash", DefTaskFileHash)
+	viper.SetDefault("public_tasks.mitre", DefTaskMitreID)
+	viper.SetDefault("public_tasks.sid", DefTaskSid)
+	viper.SetDefault("public_tasks.skip", DefTaskSkip)
 	viper.SetDefault("export.processes", DefExportProcesses)
 	viper.SetDefault("export.ATT&CK_matrix", DefExportATTCKMatrix)
+	viper.SetDefault("export.dns_queries", DefExportDNSQueries)
+	viper.SetDefault("export.connections", DefExportConnections)
+	viper.SetDefault("export.http_requests", DefExportHTTPReqs)
+	viper.SetDefault("export.dir", DefExportDir)
+	viper.SetDefault("watch.interval", DefWatchInterval)
+	viper.SetDefault("connection.hosts", DefConnHosts)
+	viper.SetDefault("connection.probe_info", DefConnProbeInfo)
+	viper.SetDefault("connection.max_retries", DefConnMaxRetries)
+	viper.SetDefault("connection.min_backoff", DefConnMinBackoff)
+	viper.SetDefault("connection.max_backoff", DefConnMaxBackoff)
+	viper.SetDefault("connection.read_timeout", DefConnReadTimeout)
+	viper.SetDefault("connection.request_timeout", DefConnReqTimeout)
+	viper.SetDefault("rate_limit.rps", DefRateLimitRPS)
+	viper.SetDefault("rate_limit.burst", DefRateLimitBurst)
+	viper.SetDefault("rate_limit.global_rps", 0)
+	viper.SetDefault("rate_limit.global_burst", 0)
+	viper.SetDefault("rate_limit.jitter", DefRateLimitJitter)
+	viper.SetDefault("ecs.index", DefECSIndex)
+	viper.SetDefault("ecs.endpoint", DefECSEndpoint)
+	viper.SetDefault("ecs.username", "")
+	viper.SetDefault("ecs.password", "")
+	viper.SetDefault("ecs.batch_size", DefECSBatchSize)
+	viper.SetDefault("ecs.flush_interval", DefECSFlushInterval)
+	viper.SetDefault("misp.url", DefMISPURL)
+	viper.SetDefault("misp.key", "")
+	viper.SetDefault("misp.distribution", DefMISPDistribution)
+	viper.SetDefault("misp.insecure", DefMISPInsecure)
+	viper.SetDefault("csv.delimiter", DefCSVDelimiter)
+	viper.SetDefault("csv.task_columns", "")
+	viper.SetDefault("csv.process_columns", "")
+	viper.SetDefault("csv.incident_columns", "")
 
 	taskTag := strings.TrimSpace(viper.GetString("public_tasks.tag"))
 	rawTaskExtensions := strings.TrimSpace(viper.GetString("public_tasks.extensions"))
 	rawTaskDetections := strings.TrimSpace(viper.GetString("public_tasks.detections"))
+	rawTaskRuntypes := strings.TrimSpace(viper.GetString("public_tasks.runtype"))
 
-	var taskExtensions []string
-	for _, ext := range strings.Split(rawTaskExtensions, ",") {
-		val, ok := SupportedTaskExtensions[ext]
-		if !ok {
-			return nil, fmt.Errorf("invalid extension '%s': possible values are %s", ext, FormatStrSlice(GetStrMapKeys(SupportedTaskExtensions)))
-		}
-		taskExtensions = append(taskExtensions, val)
+	taskIP := strings.TrimSpace(viper.GetString("public_tasks.ip"))
+	if taskIP != "" && net.ParseIP(taskIP) == nil {
+		return nil, fmt.Errorf("invalid ip '%s': must be an IPv4 or IPv6 address", taskIP)
+	}
+	taskMitreID := strings.ToUpper(strings.TrimSpace(viper.GetString("public_tasks.mitre")))
+	if taskMitreID != "" && !mitreIDPattern.MatchString(taskMitreID) {
+		return nil, fmt.Errorf("invalid ATT&CK technique '%s': must be like T1055 or T1055.012", taskMitreID)
 	}
-	var taskDetections []int
-	for _, detection := range strings.Split(rawTaskDetections, ",") {
-		val, ok := SupportedTaskDetections[detection]
-		if !ok {
-			return nil, fmt.Errorf("invalid detection '%s': possible values are %s", detection, FormatStrSlice(GetIntMapKeys(SupportedTaskDetections)))
+	taskSid := viper.GetInt("public_tasks.sid")
+	taskSkip := viper.GetInt("public_tasks.skip")
+	if taskSid < 0 || taskSkip < 0 {
+		return nil, fmt.Errorf("invalid sid '%d' or skip '%d': must not be negative", taskSid, taskSkip)
+	}
+
+	watchInterval := viper.GetDuration("watch.interval")
+	if watchInterval <= 0 {
+		return nil, fmt.Errorf("invalid watch interval '%s': must be a positive duration", viper.GetString("watch.interval"))
+	}
+
+	var connHosts []string
+	for _, host := range strings.Split(viper.GetString("connection.hosts"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			connHosts = append(connHosts, host)
 		}
-		taskDetections = append(taskDetections, val)
+	}
+	if len(connHosts) == 0 {
+		return nil, fmt.Errorf("no host configured: 'connection.hosts' must not be empty")
+	}
+	connMaxRetries := viper.GetInt("connection.max_retries")
+	if connMaxRetries < 0 {
+		return nil, fmt.Errorf("invalid max retries '%d': must not be negative", connMaxRetries)
+	}
+	connMinBackoff := viper.GetDuration("connection.min_backoff")
+	connMaxBackoff := viper.GetDuration("connection.max_backoff")
+	if connMinBackoff <= 0 || connMaxBackoff < connMinBackoff {
+		return nil, fmt.Errorf("invalid backoff range '%s'-'%s': must be positive and ordered", connMinBackoff, connMaxBackoff)
+	}
+
+	connReadTimeout := viper.GetDuration("connection.read_timeout")
+	if connReadTimeout < 0 {
+		return nil, fmt.Errorf("invalid read timeout '%s': must not be negative", viper.GetString("connection.read_timeout"))
+	}
+	connReqTimeout := viper.GetDuration("connection.request_timeout")
+	if connReqTimeout < 0 {
+		return nil, fmt.Errorf("invalid request timeout '%s': must not be negative", viper.GetString("connection.request_timeout"))
+	}
+	rateLimitJitter := viper.GetDuration("rate_limit.jitter")
+	if rateLimitJitter < 0 {
+		return nil, fmt.Errorf("invalid jitter '%s': must not be negative", rateLimitJitter)
+	}
+
+	ecsIndex := strings.TrimSpace(viper.GetString("ecs.index"))
+	if ecsIndex == "" {
+		return nil, fmt.Errorf("no index configured: 'ecs.index' must not be empty")
+	}
+	ecsBatchSize := viper.GetInt("ecs.batch_size")
+	if ecsBatchSize < 1 {
+		return nil, fmt.Errorf("invalid batch size '%d': must be at least 1", ecsBatchSize)
+	}
+	ecsFlushInterval := viper.GetDuration("ecs.flush_interval")
+	if ecsFlushInterval < 0 {
+		return nil, fmt.Errorf("invalid flush interval '%s': must not be negative", viper.GetString("ecs.flush_interval"))
+	}
+	mispURL := strings.TrimSpace(viper.GetString("misp.url"))
+	mispKey := strings.TrimSpace(viper.GetString("misp.key"))
+	if mispURL != "" && mispKey == "" {
+		return nil, fmt.Errorf("no key configured: 'misp.key' must not be empty to push events to '%s'", mispURL)
+	}
+	mispDistribution := viper.GetInt("misp.distribution")
+	if mispDistribution < 0 || mispDistribution > 3 {
+		return nil, fmt.Errorf("invalid distribution '%d': must be between 0 and 3", mispDistribution)
+	}
+	csvDelimiter := []rune(viper.GetString("csv.delimiter"))
+	if len(csvDelimiter) != 1 || csvDelimiter[0] == '"' || csvDelimiter[0] == '\n' || csvDelimiter[0] == '\r' {
+		return nil, fmt.Errorf("invalid delimiter '%s': must be a single character, e.g. \",\" or \"\\t\"", viper.GetString("csv.delimiter"))
+	}
+	csvTaskColumns, err := ParseCatalogNames("task column", viper.GetString("csv.task_columns"), GetCSVColumnNames(TaskCSVColumns))
+	if err != nil {
+		return nil, err
+	}
+	csvProcessColumns, err := ParseCatalogNames("process column", viper.GetString("csv.process_columns"), GetCSVColumnNames(ProcessCSVColumns))
+	if err != nil {
+		return nil, err
+	}
+	csvIncidentColumns, err := ParseCatalogNames("incident column", viper.GetString("csv.incident_columns"), GetCSVColumnNames(IncidentCSVColumns))
+	if err != nil {
+		return nil, err
+	}
+
+	extensionNames, err := ParseCatalogNames("extension", rawTaskExtensions, GetStrMapKeys(SupportedTaskExtensions))
+	if err != nil {
+		return nil, err
+	}
+	taskExtensions := []string{}
+	for _, name := range extensionNames {
+		taskExtensions = append(taskExtensions, SupportedTaskExtensions[name])
+	}
+	runtypeNames, err := ParseCatalogNames("run type", rawTaskRuntypes, GetStrMapKeys(SupportedTaskRuntypes))
+	if err != nil {
+		return nil, err
+	}
+	taskRuntypes := []string{}
+	for _, name := range runtypeNames {
+		taskRuntypes = append(taskRuntypes, SupportedTaskRuntypes[name])
+	}
+	detectionNames, err := ParseCatalogNames("detection", rawTaskDetections, GetIntMapKeys(SupportedTaskDetections))
+	if err != nil {
+		return nil, err
+	}
+	taskDetections := []int{}
+	for _, name := range detectionNames {
+		taskDetections = append(taskDetections, SupportedTaskDetections[name])
 	}
 	return &AppConfig{
-		taskTag:           taskTag,
-		taskIsSignificant: viper.GetBool("public_tasks.significant"),
-		taskExtensions:    taskExtensions,
-		taskDetections:    taskDetections,
-		exportProcesses:   viper.GetBool("export.processes"),
-		exportATTCKMatrix: viper.GetBool("export.ATT&CK_matrix"),
+		taskTag:            taskTag,
+		taskIsSignificant:  viper.GetBool("public_tasks.significant"),
+		taskExtensions:     taskExtensions,
+		taskDetections:     taskDetections,
+		taskHash:           strings.TrimSpace(viper.GetString("public_tasks.hash")),
+		taskRuntypes:       taskRuntypes,
+		taskIP:             taskIP,
+		taskDomain:         strings.ToLower(strings.TrimSpace(viper.GetString("public_tasks.domain"))),
+		taskFileHash:       strings.TrimSpace(viper.GetString("public_tasks.file_hash")),
+		taskMitreID:        taskMitreID,
+		taskSid:            taskSid,
+		taskSkip:           taskSkip,
+		exportProcesses:    viper.GetBool("export.processes"),
+		exportATTCKMatrix:  viper.GetBool("export.ATT&CK_matrix"),
+		exportDNSQueries:   viper.GetBool("export.dns_queries"),
+		exportConnections:  viper.GetBool("export.connections"),
+		exportHTTPRequests: viper.GetBool("export.http_requests"),
+		exportDir:          strings.TrimSpace(viper.GetString("export.dir")),
+		watchInterval:      watchInterval,
+		connHosts:          connHosts,
+		connProbeInfo:      viper.GetBool("connection.probe_info"),
+		connMaxRetries:     connMaxRetries,
+		connMinBackoff:     connMinBackoff,
+		connMaxBackoff:     connMaxBackoff,
+		connReadTimeout:    connReadTimeout,
+		connReqTimeout:     connReqTimeout,
+
+		rateLimitRPS:         viper.GetFloat64("rate_limit.rps"),
+		rateLimitBurst:       viper.GetInt("rate_limit.burst"),
+		rateLimitGlobalRPS:   viper.GetFloat64("rate_limit.global_rps"),
+		rateLimitGlobalBurst: viper.GetInt("rate_limit.global_burst"),
+		rateLimitJitter:      rateLimitJitter,
+
+		ecsIndex:         ecsIndex,
+		ecsEndpoint:      strings.TrimSpace(viper.GetString("ecs.endpoint")),
+		ecsUsername:      viper.GetString("ecs.username"),
+		ecsPassword:      viper.GetString("ecs.password"),
+		ecsBatchSize:     ecsBatchSize,
+		ecsFlushInterval: ecsFlushInterval,
+
+		mispURL:          mispURL,
+		mispKey:          mispKey,
+		mispDistribution: mispDistribution,
+		mispInsecure:     viper.GetBool("misp.insecure"),
+
+		csvDelimiter:       csvDelimiter[0],
+		csvTaskColumns:     csvTaskColumns,
+		csvProcessColumns:  csvProcessColumns,
+		csvIncidentColumns: csvIncidentColumns,
 	}, nil
 }
 
-func (config *AppConfig) ToTaskParamsJsonQuoted() string {
-	taskParams := &TaskParams{
+func (config *AppConfig) ToTaskParams() *TaskParams {
+	return &TaskParams{
 		IsPublic:    true,
-		Runtype:     []string{},
+		Hash:        config.taskHash,
+		Runtype:     config.taskRuntypes,
 		Verdict:     config.taskDetections,
 		Ext:         config.taskExtensions,
+		IP:          config.taskIP,
+		Domain:      config.taskDomain,
+		FileHash:    config.taskFileHash,
+		MitreID:     config.taskMitreID,
+		Sid:         config.taskSid,
 		Significant: config.taskIsSignificant,
 		Tag:         config.taskTag,
+		Skip:        config.taskSkip,
 	}
-	bytes, _ := json.Marshal(taskParams)
-	return strings.Trim(strconv.Quote(string(bytes)), `"`)
 }
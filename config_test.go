@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestTaskParams(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	flags := map[string]string{
+		"hash":      "44d88612fea8a8f36de82e1278abb02f",
+		"file-hash": "3395856ce81f2b7382dee72602f798b642f14140",
+		"ip":        "203.0.113.1",
+		"domain":    "Evil.Example",
+		"mitre":     "t1055.012",
+		"runtype":   "File,url",
+	}
+	for name, value := range flags {
+		viper.Set(taskFilterFlags[name], value)
+	}
+	viper.Set("public_tasks.sid", 3)
+	appConfig, err := ReadAppConfig("no-such-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeServer{numOfTasks: 10}
+	client := newFakeClient(t, server, appConfig)
+	if _, err := client.GetNumOfTasks(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetTasks(10, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	want := TaskParams{
+		IsPublic: true,
+		Hash:     "44d88612fea8a8f36de82e1278abb02f",
+		Runtype:  []string{"file", "url"},
+		Verdict:  []int{},
+		Ext:      []string{},
+		IP:       "203.0.113.1",
+		Domain:   "evil.example",
+		FileHash: "3395856ce81f2b7382dee72602f798b642f14140",
+		MitreID:  "T1055.012",
+		Sid:      3,
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.taskParams) != 2 {
+		t.Fatalf("got %d task filters, want one per request", len(server.taskParams))
+	}
+	for _, raw := range server.taskParams {
+		var params TaskParams
+		if err := json.Unmarshal([]byte(raw), &params); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%+v", params) != fmt.Sprintf("%+v", want) {
+			t.Errorf("sent filters %+v, want %+v", params, want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Crawler enriches tasks with the tabs enabled in the configuration and hands them to the exporter
+type Crawler struct {
+	client    *AppAnyClient
+	appConfig *AppConfig
+	exporter  Exporter
+	state     *CrawlState
+	// skip tasks which are already exported according to the state
+	SkipExported bool
+	// number of tasks enriched concurrently, their subscriptions share the client connection
+	Workers int
+}
+
+func NewCrawler(client *AppAnyClient, appConfig *AppConfig, exporter Exporter, state *CrawlState) *Crawler {
+	return &Crawler{
+		client:    client,
+		appConfig: appConfig,
+		exporter:  exporter,
+		state:     state,
+		Workers:   1,
+	}
+}
+
+// GetTaskReport gets all enabled tabs of the task
+func (crawler *Crawler) GetTaskReport(ctx context.Context, task *RawTask) (*TaskReport, error) {
+	var err error
+	report := &TaskReport{
+		UUID: task.Fields.UUID,
+		Task: task,
+	}
+	if crawler.appConfig.exportProcesses {
+		if report.Processes, err = crawler.client.GetProcessesCtx(ctx, task); err != nil {
+			return nil, fmt.Errorf("failed to get processes: %s", err)
+		}
+	}
+	if crawler.appConfig.exportATTCKMatrix {
+		if report.Incidents, err = crawler.client.GetIncidentsCtx(ctx, task); err != nil {
+			return nil, fmt.Errorf("failed to get incidents: %s", err)
+		}
+	}
+	if crawler.appConfig.exportDNSQueries {
+		if report.DNSQueries, err = crawler.client.GetDNSQueriesCtx(ctx, task); err != nil {
+			return nil, fmt.Errorf("failed to get dns queries: %s", err)
+		}
+	}
+	if crawler.appConfig.exportConnections {
+		if report.Connections, err = crawler.client.GetNetworkConnectionsCtx(ctx, task); err != nil {
+			return nil, fmt.Errorf("failed to get network connections: %s", err)
+		}
+	}
+	if crawler.appConfig.exportHTTPRequests {
+		if report.HTTPRequests, err = crawler.client.GetHttpRequestsCtx(ctx, task); err != nil {
+			return nil, fmt.Errorf("failed to get http requests: %s", err)
+		}
+	}
+	return report, nil
+}
+
+// CrawlTask gets the task report and exports it
+func (crawler *Crawler) CrawlTask(ctx context.Context, task *RawTask) error {
+	report, err := crawler.GetTaskReport(ctx, task)
+	if err != nil {
+		return err
+	}
+	if err := crawler.exportReport(report); err != nil {
+		return err
+	}
+	return crawler.checkpoint()
+}
+
+// checkpoint flushes the exporter and saves the state, so that no task is recorded as exported before it is written
+func (crawler *Crawler) checkpoint() error {
+	if err := crawler.exporter.Flush(); err != nil {
+		return fmt.Errorf("failed to flush exported tasks: %s", err)
+	}
+	if err := crawler.state.Save(); err != nil {
+		return fmt.Errorf("failed to save state: %s", err)
+	}
+	return nil
+}
+
+func (crawler *Crawler) exportReport(report *TaskReport) error {
+	for _, proc := range report.Processes {
+		log.Info().Msgf("[PROCESS] %d - %s - %s", proc.Fields.Pid, proc.Fields.Scores.ImportantReason, proc.Fields.Image)
+	}
+	for _, incident := range report.Incidents {
+		log.Info().Msgf("[MITRE ATT&CK] %s, %v", incident.Fields.Title, incident.Fields.Mitre)
+	}
+	if err := crawler.exporter.Export(report); err != nil {
+		return fmt.Errorf("failed to export task: %s", err)
+	}
+	return nil
+}
+
+// CrawlTasks crawls public tasks page by page so each task is exported as soon as it is enriched.
+// The last batch crawl recorded in the state is forgotten.
+func (crawler *Crawler) CrawlTasks(ctx context.Context, numOfTasks, startIndex uint) error {
+	crawler.state.ResetBatch()
+	return crawler.crawlTasks(ctx, numOfTasks, startIndex)
+}
+
+// ResumeTasks is like CrawlTasks but keeps the tasks exported by the interrupted batch crawl,
+// they are skipped if SkipExported is set
+func (crawler *Crawler) ResumeTasks(ctx context.Context, numOfTasks, startIndex uint) error {
+	return crawler.crawlTasks(ctx, numOfTasks, startIndex)
+}
+
+func (crawler *Crawler) crawlTasks(ctx context.Context, numOfTasks, startIndex uint) error {
+	endIndex := startIndex + numOfTasks
+	crawler.state.NextIndex = startIndex
+	crawler.state.EndIndex = endIndex
+	for index := startIndex; index < endIndex; index += MaxTasksPerPage {
+		pageSize := endIndex - index
+		if pageSize > MaxTasksPerPage {
+			pageSize = MaxTasksPerPage
+		}
+		tasks, err := crawler.client.GetTasksCtx(ctx, int(pageSize), int(index))
+		if err != nil {
+			return fmt.Errorf("in GetTasks: %s", err)
+		}
+		pageIndex := index
+		_, err = crawler.crawlPage(ctx, tasks, crawler.state.batchExported, func(i int) {
+			crawler.state.NextIndex = pageIndex + uint(i) + 1
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CrawlNewTasks crawls at most maxTasks tasks submitted after the newest task of the last incremental crawl.
+// The marker only moves once the crawl reaches it, so an interrupted or capped crawl is continued by the
+// next one, which skips the tasks already exported.
+func (crawler *Crawler) CrawlNewTasks(ctx context.Context, maxTasks uint) (uint, error) {
+	var first *TaskMarker
+	var count uint
+	marker := crawler.state.Newest
+	reached := false
+	for index := uint(0); count < maxTasks && !reached; index += MaxTasksPerPage {
+		tasks, err := crawler.client.GetTasksCtx(ctx, MaxTasksPerPage, int(index))
+		if err != nil {
+			return count, fmt.Errorf("in GetTasks: %s", err)
+		}
+		if len(tasks) == 0 {
+			reached = true
+			break
+		}
+		if first == nil {
+			newest := NewTaskMarker(tasks[0])
+			first = &newest
+		}
+		// cut the page at the marker or before the first task over the limit
+		var numOfNewTasks uint
+		for i, task := range tasks {
+			if marker.IsReached(task) {
+				reached = true
+				tasks = tasks[:i]
+				break
+			}
+			if crawler.SkipExported && crawler.state.newExported[task.Fields.UUID] {
+				continue
+			}
+			if count+numOfNewTasks == maxTasks {
+				tasks = tasks[:i]
+				break
+			}
+			numOfNewTasks++
+		}
+		numOfExported, err := crawler.crawlPage(ctx, tasks, crawler.state.newExported, func(int) {})
+		count += numOfExported
+		if err != nil {
+			return count, err
+		}
+	}
+	switch {
+	case first == nil:
+	case reached || marker.UUID == "":
+		crawler.state.Newest = *first
+		// tasks up to the marker are never listed again by incremental crawls
+		crawler.state.ClearNewExported()
+	default:
+		log.Warn().Msgf("stopped after %d new tasks before reaching task '%s', the next crawl continues from there", count, marker.UUID)
+	}
+	if err := crawler.checkpoint(); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// crawlPage enriches the tasks with concurrent workers and exports them in the listed order, recording them
+// in exported and calling done after each task. The state is saved once the page is done. It returns the
+// number of exported tasks.
+func (crawler *Crawler) crawlPage(ctx context.Context, tasks []*RawTask, exported exportedTasks, done func(i int)) (uint, error) {
+	type result struct {
+		report *TaskReport
+		err    error
+	}
+	// decided upfront since the state is only touched by this goroutine
+	skipped := make([]bool, len(tasks))
+	results := make([]chan result, len(tasks))
+	for i, task := range tasks {
+		skipped[i] = crawler.SkipExported && exported[task.Fields.UUID]
+		results[i] = make(chan result, 1)
+	}
+	jobs := make(chan int)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(jobs)
+		for i := range tasks {
+			if skipped[i] {
+				continue
+			}
+			select {
+			case jobs <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for w := 0; w < crawler.Workers; w++ {
+		go func() {
+			for i := range jobs {
+				if jitter := crawler.appConfig.rateLimitJitter; jitter > 0 {
+					select {
+					case <-time.After(time.Duration(rand.Int63n(int64(jitter)))):
+					case <-ctx.Done():
+					}
+				}
+				report, err := crawler.GetTaskReport(ctx, tasks[i])
+				results[i] <- result{report, err}
+			}
+		}()
+	}
+
+	var count uint
+	for i, task := range tasks {
+		log.Info().Msg(task.GetIdentity())
+		if skipped[i] {
+			log.Info().Msg("already exported, skipped")
+		} else {
+			var result result
+			select {
+			case result = <-results[i]:
+			case <-ctx.Done():
+				return count, ctx.Err()
+			}
+			if result.err != nil {
+				return count, fmt.Errorf("failed to crawl task '%s': %s", task.Fields.UUID, result.err)
+			}
+			if err := crawler.exportReport(result.report); err != nil {
+				return count, err
+			}
+			exported[task.Fields.UUID] = true
+			count++
+		}
+		done(i)
+	}
+	if err := crawler.checkpoint(); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// Watch polls for newly submitted tasks forever, at most maxTasks tasks are crawled per poll.
+// A poll which hits the limit is followed by the next one right away to catch up with the backlog.
+func (crawler *Crawler) Watch(ctx context.Context, interval time.Duration, maxTasks uint) error {
+	for {
+		numOfNewTasks, err := crawler.CrawlNewTasks(ctx, maxTasks)
+		if err != nil {
+			return err
+		}
+		if numOfNewTasks >= maxTasks {
+			log.Info().Msgf("crawled %d new tasks, polling again", numOfNewTasks)
+			continue
+		}
+		log.Info().Msgf("crawled %d new tasks, next poll in %s", numOfNewTasks, interval)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordingExporter records the uuids of the exported tasks
+type recordingExporter struct {
+	uuids []string
+	// Export fails once this many tasks are recorded, 0 means never
+	failAfter int
+}
+
+func (exporter *recordingExporter) Export(report *TaskReport) error {
+	if exporter.failAfter > 0 && len(exporter.uuids) == exporter.failAfter {
+		return errors.New("disk full")
+	}
+	exporter.uuids = append(exporter.uuids, report.UUID)
+	return nil
+}
+
+func (exporter *recordingExporter) Flush() error {
+	return nil
+}
+
+func (exporter *recordingExporter) Close() error {
+	return nil
+}
+
+func newTestCrawler(t *testing.T, server *fakeServer) (*Crawler, *recordingExporter) {
+	appConfig := &AppConfig{}
+	client := newFakeClient(t, server, appConfig)
+	state, err := LoadCrawlState(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := &recordingExporter{}
+	crawler := NewCrawler(client, appConfig, exporter, state)
+	crawler.SkipExported = true
+	crawler.Workers = 4
+	return crawler, exporter
+}
+
+// getTaskUUIDs returns the uuids of the tasks with the serials from first down to last
+func getTaskUUIDs(first, last int) []string {
+	var uuids []string
+	for serial := first; serial >= last; serial-- {
+		uuids = append(uuids, fmt.Sprintf("task-%d", serial))
+	}
+	return uuids
+}
+
+func TestCrawlNewTasks(t *testing.T) {
+	server := &fakeServer{numOfTasks: 120}
+	crawler, exporter := newTestCrawler(t, server)
+	ctx := context.Background()
+	tests := []struct {
+		numOfTasks int
+		maxTasks   uint
+		exported   []string
+		newest     string
+	}{
+		// the first crawl starts from the newest tasks
+		{120, 30, getTaskUUIDs(119, 90), "task-119"},
+		// the marker stays until the crawl reaches it
+		{200, 30, getTaskUUIDs(199, 170), "task-119"},
+		{210, 30, append(getTaskUUIDs(209, 200), getTaskUUIDs(169, 150)...), "task-119"},
+		{210, 100, getTaskUUIDs(149, 120), "task-209"},
+		{210, 100, nil, "task-209"},
+	}
+	for i, test := range tests {
+		server.setNumOfTasks(test.numOfTasks)
+		exporter.uuids = nil
+		count, err := crawler.CrawlNewTasks(ctx, test.maxTasks)
+		if err != nil {
+			t.Fatalf("crawl %d: %s", i, err)
+		}
+		if fmt.Sprint(exporter.uuids) != fmt.Sprint(test.exported) || count != uint(len(test.exported)) {
+			t.Errorf("crawl %d: exported %d tasks %v, want %v", i, count, exporter.uuids, test.exported)
+		}
+		if crawler.state.Newest.UUID != test.newest {
+			t.Errorf("crawl %d: newest task is '%s', want '%s'", i, crawler.state.Newest.UUID, test.newest)
+		}
+	}
+	if len(crawler.state.newExported) != 0 {
+		t.Errorf("%d exported tasks are kept after the marker moved", len(crawler.state.newExported))
+	}
+}
+
+func TestResumeTasks(t *testing.T) {
+	server := &fakeServer{numOfTasks: 120}
+	crawler, exporter := newTestCrawler(t, server)
+	ctx := context.Background()
+	// each step runs as a new process would, from the saved state
+	reload := func() {
+		state, err := LoadCrawlState(crawler.state.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crawler.state = state
+	}
+	crawler.state.Newest = TaskMarker{UUID: "task-119", Date: 1119}
+
+	// the batch crawl is interrupted after 59 tasks
+	crawler.SkipExported = false
+	exporter.failAfter = 59
+	if err := crawler.CrawlTasks(ctx, 100, 0); err == nil {
+		t.Fatal("the batch crawl is not interrupted")
+	}
+	crawler.state.Save()
+	reload()
+	if crawler.state.NextIndex != 59 || crawler.state.EndIndex != 100 {
+		t.Fatalf("batch crawl stopped at %d of %d, want 59 of 100", crawler.state.NextIndex, crawler.state.EndIndex)
+	}
+
+	// an incremental crawl moves its marker meanwhile, the 10 new tasks shift the indexes
+	server.setNumOfTasks(130)
+	exporter.uuids, exporter.failAfter = nil, 0
+	crawler.SkipExported = true
+	if _, err := crawler.CrawlNewTasks(ctx, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if want := getTaskUUIDs(129, 120); fmt.Sprint(exporter.uuids) != fmt.Sprint(want) {
+		t.Fatalf("incremental crawl exported %v, want %v", exporter.uuids, want)
+	}
+	reload()
+
+	// the resumed crawl still skips the tasks exported before the interruption
+	exporter.uuids = nil
+	if err := crawler.ResumeTasks(ctx, crawler.state.EndIndex-crawler.state.NextIndex, crawler.state.NextIndex); err != nil {
+		t.Fatal(err)
+	}
+	if want := getTaskUUIDs(60, 30); fmt.Sprint(exporter.uuids) != fmt.Sprint(want) {
+		t.Errorf("resumed crawl exported %v, want %v", exporter.uuids, want)
+	}
+
+	// a new batch crawl forgets the last one
+	if err := crawler.CrawlTasks(ctx, 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(crawler.state.batchExported) != 10 || crawler.state.EndIndex != 10 {
+		t.Errorf("new batch crawl records %d exported tasks up to index %d, want 10 and 10",
+			len(crawler.state.batchExported), crawler.state.EndIndex)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	server := &fakeServer{numOfTasks: 170}
+	crawler, exporter := newTestCrawler(t, server)
+	crawler.state.Newest = TaskMarker{UUID: "task-119", Date: 1119}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	// watching only ends with an error, which is the deadline once the tasks are crawled
+	if err := crawler.Watch(ctx, 10*time.Millisecond, 20); ctx.Err() == nil {
+		t.Fatal(err)
+	}
+	if want := getTaskUUIDs(169, 120); fmt.Sprint(exporter.uuids) != fmt.Sprint(want) {
+		t.Errorf("exported %v, want %v", exporter.uuids, want)
+	}
+	if crawler.state.Newest.UUID != "task-169" {
+		t.Errorf("newest task is '%s', want 'task-169'", crawler.state.Newest.UUID)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const ddpVersion = "1"
+
+var ddpSupportedVersions = []string{"1", "pre2", "pre1"}
+
+type (
+	// ObjectID is the EJSON encoding of a MongoDB object id
+	ObjectID struct {
+		Type  string `json:"$type"`
+		Value string `json:"$value"`
+	}
+
+	// client messages
+	ddpConnect struct {
+		Msg     string   `json:"msg"`
+		Version string   `json:"version"`
+		Support []string `json:"support"`
+	}
+	ddpSub struct {
+		Msg    string        `json:"msg"`
+		ID     string        `json:"id"`
+		Name   string        `json:"name"`
+		Params []interface{} `json:"params"`
+	}
+	ddpUnsub struct {
+		Msg string `json:"msg"`
+		ID  string `json:"id"`
+	}
+	ddpMethod struct {
+		Msg    string        `json:"msg"`
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+		ID     string        `json:"id"`
+	}
+	// ddpPing and ddpPong are sent by both sides
+	ddpPing struct {
+		Msg string `json:"msg"`
+		ID  string `json:"id,omitempty"`
+	}
+	ddpPong struct {
+		Msg string `json:"msg"`
+		ID  string `json:"id,omitempty"`
+	}
+
+	// server messages
+	ddpConnected struct {
+		Msg     string `json:"msg"`
+		Session string `json:"session"`
+	}
+	ddpFailed struct {
+		Msg     string `json:"msg"`
+		Version string `json:"version"`
+	}
+	ddpNosub struct {
+		Msg   string       `json:"msg"`
+		ID    string       `json:"id"`
+		Error *DDPErrorObj `json:"error"`
+	}
+	ddpReady struct {
+		Msg  string   `json:"msg"`
+		Subs []string `json:"subs"`
+	}
+	ddpAdded struct {
+		Msg        string          `json:"msg"`
+		Collection string          `json:"collection"`
+		ID         string          `json:"id"`
+		Fields     json.RawMessage `json:"fields"`
+	}
+	ddpChanged struct {
+		Msg        string          `json:"msg"`
+		Collection string          `json:"collection"`
+		ID         string          `json:"id"`
+		Fields     json.RawMessage `json:"fields"`
+		Cleared    []string        `json:"cleared"`
+	}
+	ddpRemoved struct {
+		Msg        string `json:"msg"`
+		Collection string `json:"collection"`
+		ID         string `json:"id"`
+	}
+	ddpResult struct {
+		Msg    string          `json:"msg"`
+		ID     string          `json:"id"`
+		Error  *DDPErrorObj    `json:"error"`
+		Result json.RawMessage `json:"result"`
+	}
+	ddpUpdated struct {
+		Msg     string   `json:"msg"`
+		Methods []string `json:"methods"`
+	}
+	// ddpError is the reply to a message the server could not process
+	ddpError struct {
+		Msg              string          `json:"msg"`
+		Reason           string          `json:"reason"`
+		OffendingMessage json.RawMessage `json:"offendingMessage"`
+	}
+	// DDPErrorObj is the error object carried by nosub and result messages, "error" is a number or a string
+	DDPErrorObj struct {
+		Error     interface{} `json:"error"`
+		Reason    string      `json:"reason"`
+		Message   string      `json:"message"`
+		ErrorType string      `json:"errorType"`
+	}
+)
+
+func NewObjectID(value string) ObjectID {
+	return ObjectID{
+		Type:  "oid",
+		Value: value,
+	}
+}
+
+func (obj *DDPErrorObj) String() string {
+	if obj == nil {
+		return "no reason given"
+	}
+	if obj.Message != "" {
+		return obj.Message
+	}
+	return fmt.Sprintf("%v: %s", obj.Error, obj.Reason)
+}
+
+func newDDPConnect() *ddpConnect {
+	return &ddpConnect{
+		Msg:     "connect",
+		Version: ddpVersion,
+		Support: ddpSupportedVersions,
+	}
+}
+
+func newDDPSub(id, name string, params ...interface{}) *ddpSub {
+	if params == nil {
+		params = []interface{}{}
+	}
+	return &ddpSub{
+		Msg:    "sub",
+		ID:     id,
+		Name:   name,
+		Params: params,
+	}
+}
+
+func newDDPUnsub(id string) *ddpUnsub {
+	return &ddpUnsub{
+		Msg: "unsub",
+		ID:  id,
+	}
+}
+
+func newDDPMethod(id, method string, params ...interface{}) *ddpMethod {
+	if params == nil {
+		params = []interface{}{}
+	}
+	return &ddpMethod{
+		Msg:    "method",
+		Method: method,
+		Params: params,
+		ID:     id,
+	}
+}
+
+// decodeDDPMessage returns a pointer to the typed server message, nil is returned for messages
+// which are not DDP (e.g. the initial {"server_id":"0"}) or not known
+func decodeDDPMessage(data []byte) (interface{}, error) {
+	var header struct {
+		Msg string `json:"msg"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("in Unmarshal: %s", err)
+	}
+	var msg interface{}
+	switch header.Msg {
+	case "connected":
+		msg = new(ddpConnected)
+	case "failed":
+		msg = new(ddpFailed)
+	case "ping":
+		msg = new(ddpPing)
+	case "pong":
+		msg = new(ddpPong)
+	case "nosub":
+		msg = new(ddpNosub)
+	case "ready":
+		msg = new(ddpReady)
+	case "added":
+		msg = new(ddpAdded)
+	case "changed":
+		msg = new(ddpChanged)
+	case "removed":
+		msg = new(ddpRemoved)
+	case "result":
+		msg = new(ddpResult)
+	case "updated":
+		msg = new(ddpUpdated)
+	case "error":
+		msg = new(ddpError)
+	default:
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("in Unmarshal: %s", err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecodeDDPMessage(t *testing.T) {
+	tests := []struct {
+		data    string
+		msgType string
+		err     bool
+	}{
+		{data: `{"msg":"connected","session":"0"}`, msgType: "*main.ddpConnected"},
+		{data: `{"msg":"failed","version":"pre1"}`, msgType: "*main.ddpFailed"},
+		{data: `{"msg":"ping","id":"1"}`, msgType: "*main.ddpPing"},
+		{data: `{"msg":"nosub","id":"1","error":{"error":404,"reason":"not found"}}`, msgType: "*main.ddpNosub"},
+		{data: `{"msg":"ready","subs":["1","2"]}`, msgType: "*main.ddpReady"},
+		{data: `{"msg":"added","collection":"tasks","id":"1","fields":{"uuid":"a"}}`, msgType: "*main.ddpAdded"},
+		{data: `{"msg":"changed","collection":"tasks","id":"1","cleared":["uuid"]}`, msgType: "*main.ddpChanged"},
+		{data: `{"msg":"removed","collection":"tasks","id":"1"}`, msgType: "*main.ddpRemoved"},
+		{data: `{"msg":"result","id":"1","result":{"count":1}}`, msgType: "*main.ddpResult"},
+		{data: `{"msg":"updated","methods":["1"]}`, msgType: "*main.ddpUpdated"},
+		{data: `{"msg":"error","reason":"Must connect first"}`, msgType: "*main.ddpError"},
+		// server_id and unknown messages are ignored
+		{data: `{"server_id":"0"}`, msgType: "<nil>"},
+		{data: `{"msg":"addedBefore"}`, msgType: "<nil>"},
+		{data: `[]`, err: true},
+		{data: `{"msg":"ready","subs":"1"}`, err: true},
+	}
+	for _, test := range tests {
+		msg, err := decodeDDPMessage([]byte(test.data))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: decoded an invalid message", test.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.data, err)
+		} else if msgType := fmt.Sprintf("%T", msg); msgType != test.msgType {
+			t.Errorf("%s: got %s, want %s", test.data, msgType, test.msgType)
+		}
+	}
+}
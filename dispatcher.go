@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// ddpSession is a single SockJS connection. One reader goroutine decodes every incoming message and
+	// routes it by subscription or method id to the waiting callers, so callers may run concurrently.
+	ddpSession struct {
+		conn        *websocket.Conn
+		readTimeout time.Duration
+		writeMu     sync.Mutex
+		// guards the fields below
+		mu sync.Mutex
+		// the reason the session is unusable, nil while it is alive
+		err   error
+		subs  map[string]*subscription
+		calls map[string]*methodCall
+		docs  documentCache
+		// collections whose unclaimed documents were already warned about
+		unclaimed map[string]bool
+		// called by the reader goroutine for every document event, it must not block
+		onChange func(event *DocumentEvent)
+	}
+	// subscription records the ids of the documents added to its collection until the server marks it ready,
+	// docs is then filled with their final state
+	subscription struct {
+		name       string
+		collection string
+		ids        []string
+		docs       []string
+		done       chan error
+	}
+	methodCall struct {
+		method string
+		done   chan methodResult
+	}
+	methodResult struct {
+		reply *ddpResult
+		err   error
+	}
+)
+
+func newDDPSession(conn *websocket.Conn, readTimeout time.Duration, onChange func(event *DocumentEvent)) *ddpSession {
+	return &ddpSession{
+		conn:        conn,
+		readTimeout: readTimeout,
+		subs:        make(map[string]*subscription),
+		calls:       make(map[string]*methodCall),
+		docs:        make(documentCache),
+		unclaimed:   make(map[string]bool),
+		onChange:    onChange,
+	}
+}
+
+// send writes all messages in a single frame, it is safe for concurrent use
+func (session *ddpSession) send(msgs ...interface{}) error {
+	buffer, err := encodeSockJSFrame(msgs...)
+	if err != nil {
+		return fmt.Errorf("in encodeSockJSFrame: %s", err)
+	}
+	session.writeMu.Lock()
+	defer session.writeMu.Unlock()
+	if err := session.conn.WriteMessage(websocket.TextMessage, buffer); err != nil {
+		err = fmt.Errorf("in WriteMessage: %s", err)
+		session.fail(err)
+		return err
+	}
+	return nil
+}
+
+// recv must only be called by one goroutine at a time. The server sends heartbeats regularly,
+// so a read timeout means the connection is dead even if callers are waiting without deadline.
+func (session *ddpSession) recv() (*sockJSFrame, error) {
+	if session.readTimeout > 0 {
+		session.conn.SetReadDeadline(time.Now().Add(session.readTimeout))
+	}
+	_, buffer, err := session.conn.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("in ReadMessage: %s", err)
+	}
+	frame, err := decodeSockJSFrame(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("in decodeSockJSFrame: %s", err)
+	}
+	if frame.Type == sockJSCloseFrame {
+		return nil, fmt.Errorf("session closed by server: %d %s", frame.CloseCode, frame.CloseReason)
+	}
+	return frame, nil
+}
+
+// fail marks the session as unusable and wakes up all waiting callers with the error
+func (session *ddpSession) fail(err error) {
+	session.mu.Lock()
+	if session.err == nil {
+		session.err = err
+	}
+	for id, sub := range session.subs {
+		sub.done <- err
+		delete(session.subs, id)
+	}
+	for id, call := range session.calls {
+		call.done <- methodResult{err: err}
+		delete(session.calls, id)
+	}
+	session.mu.Unlock()
+	session.conn.Close()
+}
+
+func (session *ddpSession) isBroken() bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.err != nil
+}
+
+// connect does the DDP handshake and then starts the reader goroutine
+func (session *ddpSession) connect() error {
+	if err := session.send(newDDPConnect()); err != nil {
+		return fmt.Errorf("in send: %s", err)
+	}
+	for { // skip the open frame, heartbeats and {"server_id":"0"} until the reply
+		frame, err := session.recv()
+		if err != nil {
+			session.fail(err)
+			return fmt.Errorf("in recv: %s", err)
+		}
+		for i, data := range frame.Messages {
+			msg, err := decodeDDPMessage(data)
+			if err != nil {
+				session.fail(err)
+				return fmt.Errorf("in decodeDDPMessage: %s", err)
+			}
+			switch msg := msg.(type) {
+			case *ddpConnected:
+				// the reply may share its frame with the first messages of the session
+				session.dispatchAll(frame.Messages[i+1:])
+				go session.readLoop()
+				return nil
+			case *ddpFailed:
+				err := &ErrVersionRejected{Version: ddpVersion, Proposed: msg.Version}
+				session.fail(err)
+				return err
+			}
+		}
+	}
+}
+
+func (session *ddpSession) readLoop() {
+	for {
+		frame, err := session.recv()
+		if err != nil {
+			session.fail(err)
+			return
+		}
+		session.dispatchAll(frame.Messages)
+	}
+}
+
+// dispatchAll dispatches the messages in order and reports their document events
+func (session *ddpSession) dispatchAll(msgs [][]byte) {
+	for _, data := range msgs {
+		event := session.dispatch(data)
+		if event != nil && session.onChange != nil {
+			session.onChange(event)
+		}
+	}
+}
+
+// dispatch routes the message to its waiting caller and applies document messages to the cache,
+// the resulting document event is returned if any
+func (session *ddpSession) dispatch(data []byte) *DocumentEvent {
+	msg, err := decodeDDPMessage(data)
+	if err != nil {
+		log.Debug().Err(err).Msgf("ignored invalid msg: '%s'", data)
+		return nil
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	switch msg := msg.(type) {
+	case *ddpPing:
+		go session.send(&ddpPong{Msg: "pong", ID: msg.ID})
+	case *ddpAdded:
+		if err := session.docs.add(msg); err != nil {
+			log.Debug().Err(err).Msgf("ignored invalid msg: '%s'", data)
+			return nil
+		}
+		claimed := false
+		for _, sub := range session.subs {
+			if sub.collection == msg.Collection {
+				sub.ids = append(sub.ids, msg.ID)
+				claimed = true
+			}
+		}
+		if !claimed && !session.unclaimed[msg.Collection] {
+			// a subscription expecting another collection name would otherwise silently get no documents
+			session.unclaimed[msg.Collection] = true
+			log.Warn().Msgf("document '%s' added to collection '%s' is not claimed by any pending subscription (%s)",
+				msg.ID, msg.Collection, session.getPendingSubs())
+		}
+		return session.newDocumentEvent(msg.Msg, msg.Collection, msg.ID)
+	case *ddpChanged:
+		if err := session.docs.change(msg); err != nil {
+			log.Debug().Err(err).Msgf("ignored invalid msg: '%s'", data)
+			return nil
+		}
+		return session.newDocumentEvent(msg.Msg, msg.Collection, msg.ID)
+	case *ddpRemoved:
+		session.docs.remove(msg)
+		return &DocumentEvent{Type: msg.Msg, Collection: msg.Collection, ID: msg.ID}
+	case *ddpReady:
+		for _, id := range msg.Subs {
+			if sub, ok := session.subs[id]; ok {
+				sub.done <- session.collect(sub)
+				delete(session.subs, id)
+			}
+		}
+	case *ddpNosub:
+		if sub, ok := session.subs[msg.ID]; ok {
+			sub.done <- &ErrSubscriptionRejected{Name: sub.name, Reason: msg.Error.String()}
+			delete(session.subs, msg.ID)
+		}
+	case *ddpResult:
+		if call, ok := session.calls[msg.ID]; ok {
+			if msg.Error != nil {
+				call.done <- methodResult{err: &ErrMethodFailed{Method: call.method, Reason: msg.Error.String()}}
+			} else {
+				call.done <- methodResult{reply: msg}
+			}
+			delete(session.calls, msg.ID)
+		}
+	case *ddpError:
+		session.reject(msg)
+	}
+	return nil
+}
+
+// collect fills the subscription with the current state of its documents which were not removed meanwhile,
+// it must be called with session.mu held
+func (session *ddpSession) collect(sub *subscription) error {
+	collected := make(map[string]bool)
+	for _, id := range sub.ids {
+		if collected[id] || !session.docs.has(sub.collection, id) {
+			continue
+		}
+		doc, err := session.docs.getDoc(sub.collection, id)
+		if err != nil {
+			return err
+		}
+		sub.docs = append(sub.docs, doc)
+		collected[id] = true
+	}
+	return nil
+}
+
+// getPendingSubs describes the pending subscriptions with the collection they expect,
+// it must be called with session.mu held
+func (session *ddpSession) getPendingSubs() string {
+	var pending []string
+	for _, sub := range session.subs {
+		pending = append(pending, fmt.Sprintf("%s: %s", sub.name, sub.collection))
+	}
+	sort.Strings(pending)
+	return strings.Join(pending, ", ")
+}
+
+// newDocumentEvent must be called with session.mu held
+func (session *ddpSession) newDocumentEvent(eventType, collection, id string) *DocumentEvent {
+	if session.onChange == nil {
+		return nil
+	}
+	fields, err := session.docs.getFields(collection, id)
+	if err != nil {
+		log.Debug().Err(err).Msgf("failed to get fields of document '%s'", id)
+		return nil
+	}
+	return &DocumentEvent{Type: eventType, Collection: collection, ID: id, Fields: fields}
+}
+
+// reject fails the subscription or method whose message the server could not process,
+// it must be called with session.mu held
+func (session *ddpSession) reject(msg *ddpError) {
+	var offending struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(msg.OffendingMessage, &offending)
+	if sub, ok := session.subs[offending.ID]; ok && offending.ID != "" {
+		sub.done <- &ErrSubscriptionRejected{Name: sub.name, Reason: msg.Reason}
+		delete(session.subs, offending.ID)
+		return
+	}
+	if call, ok := session.calls[offending.ID]; ok && offending.ID != "" {
+		call.done <- methodResult{err: &ErrMethodFailed{Method: call.method, Reason: msg.Reason}}
+		delete(session.calls, offending.ID)
+		return
+	}
+	log.Warn().Msgf("server could not process '%s': %s", msg.OffendingMessage, msg.Reason)
+}
+
+// subscribe sends the subscription message and waits for it to be ready, it returns the documents
+// added to the collection meanwhile, in their final state and in the form of "added" messages.
+// The subscription is stopped afterwards so that the server does not keep the documents in its
+// merge box, or as soon as the context is done.
+func (session *ddpSession) subscribe(ctx context.Context, collection string, msg *ddpSub) ([]string, error) {
+	sub := &subscription{
+		name:       msg.Name,
+		collection: collection,
+		done:       make(chan error, 1),
+	}
+	session.mu.Lock()
+	if session.err != nil {
+		session.mu.Unlock()
+		return nil, session.err
+	}
+	session.subs[msg.ID] = sub
+	session.mu.Unlock()
+
+	if err := session.send(msg); err != nil {
+		return nil, err
+	}
+	select {
+	case err := <-sub.done:
+		if err != nil {
+			return nil, err
+		}
+	case <-ctx.Done():
+		session.mu.Lock()
+		delete(session.subs, msg.ID)
+		session.mu.Unlock()
+		session.send(newDDPUnsub(msg.ID))
+		return nil, ctx.Err()
+	}
+	session.send(newDDPUnsub(msg.ID))
+	return sub.docs, nil
+}
+
+// call sends the method message and waits for its result message. DDP has no way to cancel
+// a method, so when the context is done its result is just ignored.
+func (session *ddpSession) call(ctx context.Context, msg *ddpMethod) (*ddpResult, error) {
+	call := &methodCall{
+		method: msg.Method,
+		done:   make(chan methodResult, 1),
+	}
+	session.mu.Lock()
+	if session.err != nil {
+		session.mu.Unlock()
+		return nil, session.err
+	}
+	session.calls[msg.ID] = call
+	session.mu.Unlock()
+
+	if err := session.send(msg); err != nil {
+		return nil, err
+	}
+	select {
+	case result := <-call.done:
+		return result.reply, result.err
+	case <-ctx.Done():
+		session.mu.Lock()
+		delete(session.calls, msg.ID)
+		session.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
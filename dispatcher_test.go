@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUnclaimedDocuments(t *testing.T) {
+	server := &fakeServer{renamed: map[string]string{processesCollection: "processTree"}}
+	client := newFakeClient(t, server, &AppConfig{})
+	processes, err := client.GetProcesses(&RawTask{ID: "oid-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processes) != 0 {
+		t.Errorf("got %d processes of another collection", len(processes))
+	}
+	session := client.getSession()
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.unclaimed["processTree"] {
+		t.Error("the documents of the unexpected collection are not warned about")
+	}
+}
+
+func TestSubscriptionRejected(t *testing.T) {
+	for _, reply := range []string{"nosub", "error"} {
+		server := &fakeServer{rejected: map[string]string{"process": reply, "taskexists": reply}}
+		client := newFakeClient(t, server, &AppConfig{})
+		var rejected *ErrSubscriptionRejected
+		_, err := client.GetProcesses(&RawTask{ID: "oid-1"})
+		if !errors.As(err, &rejected) || rejected.Name != "process" {
+			t.Errorf("%s: got error '%v', want the process subscription rejected", reply, err)
+		}
+		// the typed error goes through the lookup of the task
+		_, err = client.GetTaskByUUID("task-1")
+		if !errors.As(err, &rejected) || rejected.Name != "taskexists" {
+			t.Errorf("%s: got error '%v', want the taskexists subscription rejected", reply, err)
+		}
+		// the session is still usable
+		if _, err := client.GetIncidents(&RawTask{ID: "oid-1"}); err != nil {
+			t.Errorf("%s: %s", reply, err)
+		}
+	}
+}
+
+func TestVersionRejected(t *testing.T) {
+	client := dialFakeServer(t, &fakeServer{failedVersion: "pre2"}, &AppConfig{})
+	var rejected *ErrVersionRejected
+	if err := client.Connect(); !errors.As(err, &rejected) || rejected.Proposed != "pre2" {
+		t.Fatalf("got error '%v', want the version rejected in favor of pre2", err)
+	}
+}
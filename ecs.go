@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ecsVersion    = "1.12.0"
+	taskURLFormat = "https://app.any.run/tasks/%s"
+)
+
+// ECSDocument is an Elastic Common Schema document, fields are set by their dotted names e.g. "process.parent.pid"
+type ECSDocument map[string]interface{}
+
+// Set stores the value under the dotted name, nil, empty strings and empty slices are skipped
+func (doc ECSDocument) Set(name string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case string:
+		if v == "" {
+			return
+		}
+	case []string:
+		if len(v) == 0 {
+			return
+		}
+	}
+	parent := doc
+	keys := strings.Split(name, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := parent[key].(ECSDocument)
+		if !ok {
+			child = make(ECSDocument)
+			parent[key] = child
+		}
+		parent = child
+	}
+	parent[keys[len(keys)-1]] = value
+}
+
+// GetID returns event.id, which is the object id of the document it comes from
+func (doc ECSDocument) GetID() string {
+	event, _ := doc["event"].(ECSDocument)
+	id, _ := event["id"].(string)
+	return id
+}
+
+// NewECSDocuments maps the task, its processes and its incidents to ECS documents
+func NewECSDocuments(report *TaskReport) []ECSDocument {
+	docs := make([]ECSDocument, 0, 1+len(report.Processes)+len(report.Incidents))
+	task := report.Task
+	taskDate := formatDate(task.Fields.Date.Date)
+	reference := fmt.Sprintf(taskURLFormat, report.UUID)
+
+	newDoc := func(dataset string, timestamp interface{}) ECSDocument {
+		doc := make(ECSDocument)
+		if timestamp == nil {
+			timestamp = taskDate
+		}
+		doc.Set("@timestamp", timestamp)
+		doc.Set("ecs.version", ecsVersion)
+		doc.Set("event.module", "anyrun")
+		doc.Set("event.dataset", dataset)
+		doc.Set("event.reference", reference)
+		doc.Set("anyrun.task.uuid", report.UUID)
+		return doc
+	}
+
+	mainObject := task.Fields.Public.Objects.MainObject
+	doc := newDoc("anyrun.task", nil)
+	doc.Set("event.kind", "enrichment")
+	doc.Set("event.category", []string{"malware"})
+	doc.Set("event.id", task.ID)
+	doc.Set("event.risk_score", task.Fields.Scores.Verdict.ThreatLevel)
+	doc.Set("file.name", mainObject.Names.Basename)
+	doc.Set("file.mime_type", mainObject.Info.Meta.Mime)
+	doc.Set("file.extension", mainObject.Content.Ext)
+	doc.Set("file.hash.md5", mainObject.Hashes.Md5)
+	doc.Set("file.hash.sha1", mainObject.Hashes.Sha1)
+	doc.Set("file.hash.sha256", mainObject.Hashes.Sha256)
+	doc.Set("file.hash.ssdeep", mainObject.Hashes.Ssdeep)
+	doc.Set("url.full", mainObject.Names.URL)
+	doc.Set("tags", task.Fields.Tags)
+	doc.Set("threat.framework", "MITRE ATT&CK")
+	doc.Set("threat.technique.id", report.GetTechniques())
+	doc.Set("anyrun.task.run_type", task.Fields.Public.Objects.RunType)
+	doc.Set("anyrun.task.verdict", task.Fields.Scores.Verdict.Text)
+	doc.Set("anyrun.task.significant", task.Fields.Significant)
+	docs = append(docs, doc)
+
+	for _, proc := range report.Processes {
+		doc := newDoc("anyrun.process", formatDate(proc.Fields.Times.Created.Date))
+		doc.Set("event.kind", "event")
+		doc.Set("event.category", []string{"process"})
+		doc.Set("event.type", []string{"start"})
+		doc.Set("event.id", proc.ID)
+		doc.Set("event.end", formatDate(proc.Fields.Times.Closed.Date))
+		doc.Set("process.entity_id", proc.ID)
+		doc.Set("process.pid", proc.Fields.Pid)
+		doc.Set("process.parent.pid", proc.Fields.ParentPID)
+		doc.Set("process.executable", proc.Fields.Image)
+		doc.Set("process.name", getBaseName(proc.Fields.Image))
+		doc.Set("process.command_line", proc.Fields.Cmd)
+		doc.Set("process.pe.description", proc.Fields.Version.Description)
+		doc.Set("process.pe.company", proc.Fields.Version.Company)
+		doc.Set("process.pe.file_version", proc.Fields.Version.Version)
+		doc.Set("user.name", proc.Fields.User.Name)
+		doc.Set("user.id", proc.Fields.User.Sid)
+		doc.Set("anyrun.process.integrity_level", proc.Fields.User.Il)
+		doc.Set("anyrun.process.important", proc.Fields.Important)
+		doc.Set("anyrun.process.important_reason", proc.Fields.Scores.ImportantReason)
+		docs = append(docs, doc)
+	}
+
+	for _, incident := range report.Incidents {
+		doc := newDoc("anyrun.incident", formatDate(incident.Fields.FirstSeen.Date))
+		doc.Set("event.kind", "alert")
+		doc.Set("event.category", []string{"intrusion_detection"})
+		doc.Set("event.id", incident.ID)
+		doc.Set("event.severity", incident.Fields.Threatlevel)
+		doc.Set("rule.name", incident.Fields.Title)
+		doc.Set("process.entity_id", incident.Fields.ProcessOID.Value)
+		doc.Set("threat.framework", "MITRE ATT&CK")
+		doc.Set("threat.technique.id", incident.Fields.Mitre)
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// getBaseName returns the last element of a Windows or Unix path
+func getBaseName(path string) string {
+	return path[strings.LastIndexAny(path, `\/`)+1:]
+}
@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+type (
+	// ErrSubscriptionRejected is returned when the server answers a subscription with nosub or error instead of ready
+	ErrSubscriptionRejected struct {
+		Name   string
+		Reason string
+	}
+	// ErrMethodFailed is returned when the result of a method carries an error, or the server could not process the call
+	ErrMethodFailed struct {
+		Method string
+		Reason string
+	}
+	// ErrVersionRejected is returned by Connect when the server does not support the requested DDP version
+	ErrVersionRejected struct {
+		Version  string
+		Proposed string
+	}
+)
+
+func (err *ErrSubscriptionRejected) Error() string {
+	return fmt.Sprintf("subscription '%s' rejected by server: %s", err.Name, err.Reason)
+}
+
+func (err *ErrMethodFailed) Error() string {
+	return fmt.Sprintf("method '%s' failed: %s", err.Method, err.Reason)
+}
+
+func (err *ErrVersionRejected) Error() string {
+	return fmt.Sprintf("server does not support DDP version %s, it proposed version %s", err.Version, err.Proposed)
+}
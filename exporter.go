@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type (
+	// Exporter saves crawled tasks somewhere. Flush is called before the crawl state records the exported
+	// tasks, so buffered tasks must be written by then. Close is called once crawling is done.
+	Exporter interface {
+		Export(report *TaskReport) error
+		Flush() error
+		Close() error
+	}
+	// Exporters sends each report to all underlying exporters
+	Exporters []Exporter
+	// JSONFileExporter writes one JSON document per task into a directory
+	JSONFileExporter struct {
+		dir string
+	}
+)
+
+func (exporters Exporters) Export(report *TaskReport) error {
+	for _, exporter := range exporters {
+		if err := exporter.Export(report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (exporters Exporters) Flush() error {
+	for _, exporter := range exporters {
+		if err := exporter.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (exporters Exporters) Close() error {
+	var firstErr error
+	for _, exporter := range exporters {
+		if err := exporter.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func NewJSONFileExporter(dir string) (*JSONFileExporter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dir for saving: %s", err)
+	}
+	return &JSONFileExporter{
+		dir: dir,
+	}, nil
+}
+
+// GetFilePath returns the path of the file the task is saved to
+func (exporter *JSONFileExporter) GetFilePath(taskUuid string) string {
+	return filepath.Join(exporter.dir, taskUuid+".json")
+}
+
+func (exporter *JSONFileExporter) Export(report *TaskReport) error {
+	bytes, err := json.MarshalIndent(report, "", " ")
+	if err != nil {
+		return fmt.Errorf("in MarshalIndent: %s", err)
+	}
+	if err := ioutil.WriteFile(exporter.GetFilePath(report.UUID), bytes, 0644); err != nil {
+		return fmt.Errorf("in WriteFile: %s", err)
+	}
+	return nil
+}
+
+func (exporter *JSONFileExporter) Flush() error {
+	return nil
+}
+
+func (exporter *JSONFileExporter) Close() error {
+	return nil
+}
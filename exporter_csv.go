@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// CSVExporterConfig selects the columns of each file, all columns are written if none is selected
+	CSVExporterConfig struct {
+		Dir             string
+		Delimiter       rune
+		TaskColumns     []string
+		ProcessColumns  []string
+		IncidentColumns []string
+	}
+	// CSVExporter appends tasks, their processes and their incidents to tasks.csv, processes.csv and
+	// incidents.csv, one row per document. The header is written when a file is created, existing files
+	// must have the selected columns.
+	CSVExporter struct {
+		tasks     *csvTable
+		processes *csvTable
+		incidents *csvTable
+	}
+	csvTable struct {
+		file    *os.File
+		writer  *csv.Writer
+		columns []*csvColumn
+	}
+	csvColumn struct {
+		name string
+		get  func(row *csvRow) string
+	}
+	// csvRow is the document a row is written for, along with its task
+	csvRow struct {
+		report   *TaskReport
+		process  *RawProcess
+		incident *RawIncident
+	}
+)
+
+var (
+	TaskCSVColumns = []*csvColumn{
+		{"uuid", func(row *csvRow) string { return row.report.UUID }},
+		{"date", func(row *csvRow) string { return formatCSVDate(row.report.Task.Fields.Date.Date) }},
+		{"type", func(row *csvRow) string { return row.report.Task.Fields.Public.Objects.MainObject.Type }},
+		{"run_type", func(row *csvRow) string { return row.report.Task.Fields.Public.Objects.RunType }},
+		{"name", func(row *csvRow) string { return row.report.Task.Fields.Public.Objects.MainObject.Names.Basename }},
+		{"url", func(row *csvRow) string { return row.report.Task.Fields.Public.Objects.MainObject.Names.URL }},
+		{"md5", func(row *csvRow) string { return row.report.Task.Fields.Public.Objects.MainObject.Hashes.Md5 }},
+		{"sha1", func(row *csvRow) string { return row.report.Task.Fields.Public.Objects.MainObject.Hashes.Sha1 }},
+		{"sha256", func(row *csvRow) string { return row.report.Task.Fields.Public.Objects.MainObject.Hashes.Sha256 }},
+		{"ssdeep", func(row *csvRow) string { return row.report.Task.Fields.Public.Objects.MainObject.Hashes.Ssdeep }},
+		{"verdict", func(row *csvRow) string { return row.report.Task.Fields.Scores.Verdict.Text }},
+		{"threat_level", func(row *csvRow) string { return strconv.Itoa(row.report.Task.Fields.Scores.Verdict.ThreatLevel) }},
+		{"significant", func(row *csvRow) string { return strconv.FormatBool(row.report.Task.Fields.Significant) }},
+		{"tags", func(row *csvRow) string { return strings.Join(row.report.Task.Fields.Tags, ";") }},
+		{"techniques", func(row *csvRow) string { return strings.Join(row.report.GetTechniques(), ";") }},
+	}
+	ProcessCSVColumns = append([]*csvColumn{
+		{"task_uuid", func(row *csvRow) string { return row.report.UUID }},
+		{"oid", func(row *csvRow) string { return row.process.ID }},
+		{"pid", func(row *csvRow) string { return strconv.Itoa(row.process.Fields.Pid) }},
+		{"parent_pid", func(row *csvRow) string { return strconv.Itoa(row.process.Fields.ParentPID) }},
+		{"image", func(row *csvRow) string { return row.process.Fields.Image }},
+		{"cmd", func(row *csvRow) string { return row.process.Fields.Cmd }},
+		{"user", func(row *csvRow) string { return row.process.Fields.User.Name }},
+		{"integrity_level", func(row *csvRow) string { return row.process.Fields.User.Il }},
+		{"important_reason", func(row *csvRow) string { return row.process.Fields.Scores.ImportantReason }},
+	}, getSpecCSVColumns()...)
+	IncidentCSVColumns = []*csvColumn{
+		{"task_uuid", func(row *csvRow) string { return row.report.UUID }},
+		{"oid", func(row *csvRow) string { return row.incident.ID }},
+		{"process_oid", func(row *csvRow) string { return row.incident.Fields.ProcessOID.Value }},
+		{"threat_level", func(row *csvRow) string { return strconv.Itoa(row.incident.Fields.Threatlevel) }},
+		{"title", func(row *csvRow) string { return row.incident.Fields.Title }},
+		{"techniques", func(row *csvRow) string { return strings.Join(row.incident.Fields.Mitre, ";") }},
+	}
+)
+
+// getSpecCSVColumns returns a "spec_<name>" column per flag of ProcessSpecs
+func getSpecCSVColumns() []*csvColumn {
+	specsType := reflect.TypeOf(ProcessSpecs{})
+	columns := make([]*csvColumn, 0, specsType.NumField())
+	for i := 0; i < specsType.NumField(); i++ {
+		index := i
+		columns = append(columns, &csvColumn{"spec_" + specsType.Field(i).Tag.Get("json"), func(row *csvRow) string {
+			return strconv.FormatBool(reflect.ValueOf(row.process.Fields.Scores.Specs).Field(index).Bool())
+		}})
+	}
+	return columns
+}
+
+func NewCSVExporter(config *CSVExporterConfig) (*CSVExporter, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dir for saving: %s", err)
+	}
+	exporter := new(CSVExporter)
+	var err error
+	if exporter.tasks, err = newCSVTable(config, "tasks.csv", TaskCSVColumns, config.TaskColumns); err != nil {
+		return nil, err
+	}
+	if exporter.processes, err = newCSVTable(config, "processes.csv", ProcessCSVColumns, config.ProcessColumns); err != nil {
+		exporter.Close()
+		return nil, err
+	}
+	if exporter.incidents, err = newCSVTable(config, "incidents.csv", IncidentCSVColumns, config.IncidentColumns); err != nil {
+		exporter.Close()
+		return nil, err
+	}
+	return exporter, nil
+}
+
+// newCSVTable opens the file for appending rows of the selected columns, in the order they are selected
+func newCSVTable(config *CSVExporterConfig, name string, columns []*csvColumn, selected []string) (*csvTable, error) {
+	table := &csvTable{
+		columns: columns,
+	}
+	if len(selected) > 0 {
+		table.columns = make([]*csvColumn, 0, len(selected))
+		for _, name := range selected {
+			for _, column := range columns {
+				if column.name == name {
+					table.columns = append(table.columns, column)
+				}
+			}
+		}
+	}
+	path := filepath.Join(config.Dir, name)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("in OpenFile: %s", err)
+	}
+	table.file = file
+	table.writer = csv.NewWriter(file)
+	table.writer.Comma = config.Delimiter
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("in Stat: %s", err)
+	}
+	header := GetCSVColumnNames(table.columns)
+	if info.Size() == 0 {
+		if err := table.write(header); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return table, nil
+	}
+	// appended rows must line up with the header of the file
+	reader := csv.NewReader(file)
+	reader.Comma = config.Delimiter
+	existing, err := reader.Read()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read the header of '%s': %s", path, err)
+	}
+	if columns, selected := FormatStrSlice(existing), FormatStrSlice(header); columns != selected {
+		file.Close()
+		return nil, fmt.Errorf("'%s' has the columns %s instead of the selected %s, select the same columns or use another directory",
+			path, columns, selected)
+	}
+	return table, nil
+}
+
+func (table *csvTable) write(record []string) error {
+	if err := table.writer.Write(record); err != nil {
+		return fmt.Errorf("in Write: %s", err)
+	}
+	return nil
+}
+
+func (table *csvTable) writeRow(row *csvRow) error {
+	record := make([]string, 0, len(table.columns))
+	for _, column := range table.columns {
+		record = append(record, escapeCSVCell(column.get(row)))
+	}
+	return table.write(record)
+}
+
+// escapeCSVCell keeps spreadsheets from evaluating cells as formulas, e.g. a command line starting with "="
+func escapeCSVCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// flush makes rows written so far visible, so that files can be opened while crawling
+func (table *csvTable) flush() error {
+	table.writer.Flush()
+	if err := table.writer.Error(); err != nil {
+		return fmt.Errorf("in Flush: %s", err)
+	}
+	return nil
+}
+
+func (table *csvTable) close() error {
+	if table == nil {
+		return nil
+	}
+	err := table.flush()
+	if closeErr := table.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (exporter *CSVExporter) Export(report *TaskReport) error {
+	if err := exporter.tasks.writeRow(&csvRow{report: report}); err != nil {
+		return err
+	}
+	for _, proc := range report.Processes {
+		if err := exporter.processes.writeRow(&csvRow{report: report, process: proc}); err != nil {
+			return err
+		}
+	}
+	for _, incident := range report.Incidents {
+		if err := exporter.incidents.writeRow(&csvRow{report: report, incident: incident}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the buffered rows of all tables
+func (exporter *CSVExporter) Flush() error {
+	for _, table := range []*csvTable{exporter.tasks, exporter.processes, exporter.incidents} {
+		if err := table.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (exporter *CSVExporter) Close() error {
+	var firstErr error
+	for _, table := range []*csvTable{exporter.tasks, exporter.processes, exporter.incidents} {
+		if err := table.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// GetCSVColumnNames returns the names of the columns, as selected in the configuration
+func GetCSVColumnNames(columns []*csvColumn) []string {
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, column.name)
+	}
+	return names
+}
+
+// formatCSVDate converts an EJSON $date in milliseconds to a date spreadsheets understand
+func formatCSVDate(millis int64) string {
+	if millis == 0 {
+		return ""
+	}
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC().Format("2006-01-02 15:04:05")
+}
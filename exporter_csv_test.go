@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCSVExporter(t *testing.T) {
+	dir := t.TempDir()
+	config := &CSVExporterConfig{
+		Dir:            dir,
+		Delimiter:      ';',
+		ProcessColumns: []string{"pid", "image", "cmd"},
+	}
+	// rows of a second run are appended below the same header
+	for i := 0; i < 2; i++ {
+		exporter, err := NewCSVExporter(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exporter.Export(newTestReport(t)); err != nil {
+			t.Fatal(err)
+		}
+		if err := exporter.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file, err := os.Open(filepath.Join(dir, "processes.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = ';'
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"pid", "image", "cmd"},
+		{"100", `C:\Windows\winword.exe`, "winword.exe /n invoice.doc"},
+		// formulas are escaped
+		{"101", `C:\Windows\cmd.exe`, "'=cmd /c calc"},
+	}
+	want = append(want, want[1:]...)
+	if FormatStrSlice(flattenCSVRecords(records)) != FormatStrSlice(flattenCSVRecords(want)) {
+		t.Errorf("got %v, want %v", records, want)
+	}
+
+	// another selection of columns does not fit the existing file
+	config.ProcessColumns = []string{"pid", "cmd"}
+	if _, err := NewCSVExporter(config); err == nil || !strings.Contains(err.Error(), "instead of the selected") {
+		t.Errorf("got %v, want a column mismatch", err)
+	}
+}
+
+func flattenCSVRecords(records [][]string) []string {
+	var cells []string
+	for _, record := range records {
+		cells = append(cells, strings.Join(record, "|"))
+	}
+	return cells
+}
+
+func TestEscapeCSVCell(t *testing.T) {
+	tests := []struct {
+		value   string
+		escaped string
+	}{
+		{"", ""},
+		{"calc.exe", "calc.exe"},
+		{"=1+1", "'=1+1"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tx", "'\tx"},
+		{"a=b", "a=b"},
+	}
+	for _, test := range tests {
+		if escaped := escapeCSVCell(test.value); escaped != test.escaped {
+			t.Errorf("%q: got %q, want %q", test.value, escaped, test.escaped)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// ECSBulkExporterConfig tells where ECSBulkExporter sends documents, at least a file or an endpoint is expected
+	ECSBulkExporterConfig struct {
+		// the index documents are sent to
+		Index string
+		// the NDJSON file bulk requests are appended to, empty means none
+		FilePath string
+		// the base URL of Elasticsearch or OpenSearch bulk requests are posted to, empty means none
+		Endpoint string
+		Username string
+		Password string
+		// number of documents sent per bulk request
+		BatchSize int
+		// pending documents are sent once the oldest of them waited this long, 0 means they wait for a full batch
+		FlushInterval time.Duration
+	}
+	// ECSBulkExporter writes task reports as ECS documents in the _bulk format to a file and/or an endpoint.
+	// Documents are indexed by object id, so re-crawled tasks overwrite their documents.
+	ECSBulkExporter struct {
+		config     *ECSBulkExporterConfig
+		file       io.WriteCloser
+		httpClient *http.Client
+		// the pending bulk request, its number of documents and when the first of them was added
+		batch      bytes.Buffer
+		batchDocs  int
+		batchSince time.Time
+		// the number of bytes of the pending request already written to the file
+		batchWritten int
+	}
+	// bulkResponse is the part of the _bulk response telling which documents failed
+	bulkResponse struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+)
+
+func NewECSBulkExporter(config *ECSBulkExporterConfig) (*ECSBulkExporter, error) {
+	exporter := &ECSBulkExporter{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: time.Minute,
+		},
+	}
+	if config.FilePath != "" {
+		file, err := os.OpenFile(config.FilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("in OpenFile: %s", err)
+		}
+		exporter.file = file
+	}
+	return exporter, nil
+}
+
+func (exporter *ECSBulkExporter) Export(report *TaskReport) error {
+	if exporter.batchDocs == 0 {
+		exporter.batchSince = time.Now()
+	}
+	for _, doc := range NewECSDocuments(report) {
+		action := map[string]interface{}{
+			"index": map[string]string{
+				"_index": exporter.config.Index,
+				"_id":    doc.GetID(),
+			},
+		}
+		for _, line := range []interface{}{action, doc} {
+			buffer, err := json.Marshal(line)
+			if err != nil {
+				return fmt.Errorf("in Marshal: %s", err)
+			}
+			exporter.batch.Write(buffer)
+			exporter.batch.WriteByte('\n')
+		}
+		exporter.batchDocs++
+	}
+	if exporter.batchDocs >= exporter.config.BatchSize {
+		return exporter.Flush()
+	}
+	if interval := exporter.config.FlushInterval; interval > 0 && time.Since(exporter.batchSince) >= interval {
+		return exporter.Flush()
+	}
+	return nil
+}
+
+// Flush writes the pending bulk request to the file and posts it to the endpoint
+func (exporter *ECSBulkExporter) Flush() error {
+	if exporter.batchDocs == 0 {
+		return nil
+	}
+	// a failed request is kept to be sent again, documents are indexed by id so resending them is harmless.
+	// The file only gets the documents added since, it is not indexed.
+	if exporter.file != nil {
+		if _, err := exporter.file.Write(exporter.batch.Bytes()[exporter.batchWritten:]); err != nil {
+			return fmt.Errorf("in Write: %s", err)
+		}
+		exporter.batchWritten = exporter.batch.Len()
+	}
+	if exporter.config.Endpoint != "" {
+		if err := exporter.post(exporter.batch.Bytes()); err != nil {
+			return fmt.Errorf("failed to post %d documents: %s", exporter.batchDocs, err)
+		}
+	}
+	exporter.batch.Reset()
+	exporter.batchDocs = 0
+	exporter.batchWritten = 0
+	return nil
+}
+
+func (exporter *ECSBulkExporter) post(body []byte) error {
+	url := strings.TrimSuffix(exporter.config.Endpoint, "/") + "/_bulk"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("in NewRequest: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if exporter.config.Username != "" {
+		req.SetBasicAuth(exporter.config.Username, exporter.config.Password)
+	}
+	resp, err := exporter.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("in Do: %s", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("in ReadAll: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, respBody)
+	}
+	result := new(bulkResponse)
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return fmt.Errorf("in Unmarshal: %s", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	// report the first failure, the others are most likely alike
+	for _, item := range result.Items {
+		for _, status := range item {
+			if status.Status >= 300 {
+				return fmt.Errorf("document '%s' rejected with status %d: %s: %s", status.ID, status.Status, status.Error.Type, status.Error.Reason)
+			}
+		}
+	}
+	return fmt.Errorf("bulk request partially failed")
+}
+
+// Close sends the remaining documents
+func (exporter *ECSBulkExporter) Close() error {
+	err := exporter.Flush()
+	if exporter.file != nil {
+		if closeErr := exporter.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBulkServer records the bodies of _bulk requests and rejects them while failing
+type fakeBulkServer struct {
+	bodies  []string
+	failing bool
+}
+
+func (server *fakeBulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	server.bodies = append(server.bodies, string(body))
+	if server.failing {
+		w.Write([]byte(`{"errors":true,"items":[{"index":{"_id":"x","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`))
+		return
+	}
+	w.Write([]byte(`{"errors":false,"items":[]}`))
+}
+
+func TestECSBulkExporter(t *testing.T) {
+	server := &fakeBulkServer{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	path := filepath.Join(t.TempDir(), "bulk.ndjson")
+	config := &ECSBulkExporterConfig{
+		Index:     "anyrun",
+		FilePath:  path,
+		Endpoint:  httpServer.URL + "/",
+		BatchSize: 100,
+	}
+	exporter, err := NewECSBulkExporter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := newTestReport(t)
+	numOfLines := 2 * len(NewECSDocuments(report))
+
+	// documents wait for a full batch or a flush
+	if err := exporter.Export(report); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.bodies) != 0 {
+		t.Fatalf("posted %d requests before the batch is full", len(server.bodies))
+	}
+	if err := exporter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.bodies) != 1 || strings.Count(server.bodies[0], "\n") != numOfLines {
+		t.Fatalf("got %d requests, want 1 of %d lines", len(server.bodies), numOfLines)
+	}
+	if !strings.HasPrefix(server.bodies[0], `{"index":{"_id":"task-oid","_index":"anyrun"}}`) {
+		t.Errorf("unexpected request %s", server.bodies[0])
+	}
+
+	// rejected documents are kept and sent again by the next flush along with the new ones
+	server.failing = true
+	if err := exporter.Export(report); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Flush(); err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Fatalf("got %v, want the rejection", err)
+	}
+	server.failing = false
+	if err := exporter.Export(report); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.bodies) != 3 || server.bodies[2] != server.bodies[1]+server.bodies[1] {
+		t.Fatalf("got %d requests, want the rejected one sent again with the new documents", len(server.bodies))
+	}
+
+	// a full batch or an expired interval is sent right away
+	config.BatchSize = 1
+	if err := exporter.Export(report); err != nil {
+		t.Fatal(err)
+	}
+	config.BatchSize = 100
+	config.FlushInterval = time.Nanosecond
+	if err := exporter.Export(report); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.bodies) != 5 {
+		t.Fatalf("got %d requests, want 5", len(server.bodies))
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// each document is appended to the file once, even if its request is sent again
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := server.bodies[0] + strings.Join(server.bodies[2:], ""); !bytes.Equal(data, []byte(want)) {
+		t.Errorf("the file does not match the requests")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// JSONLExporter streams one TaskRecord per line to a file or stdout
+type JSONLExporter struct {
+	writer  io.WriteCloser
+	encoder *json.Encoder
+}
+
+// NewJSONLExporter appends records to the file at the path, "-" means stdout
+func NewJSONLExporter(path string) (*JSONLExporter, error) {
+	var writer io.WriteCloser
+	if path == "-" {
+		writer = os.Stdout
+	} else {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("in OpenFile: %s", err)
+		}
+		writer = file
+	}
+	return &JSONLExporter{
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}, nil
+}
+
+func (exporter *JSONLExporter) Export(report *TaskReport) error {
+	if err := exporter.encoder.Encode(NewTaskRecord(report)); err != nil {
+		return fmt.Errorf("in Encode: %s", err)
+	}
+	return nil
+}
+
+func (exporter *JSONLExporter) Flush() error {
+	return nil
+}
+
+func (exporter *JSONLExporter) Close() error {
+	if exporter.writer == os.Stdout {
+		return nil
+	}
+	return exporter.writer.Close()
+}
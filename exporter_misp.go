@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	// MISPExporterConfig tells where MISPExporter sends events, at least a directory or a URL is expected
+	MISPExporterConfig struct {
+		// the directory one event file is written to per task, empty means none
+		Dir string
+		// the base URL of the MISP instance events are pushed to, empty means none
+		URL string
+		// the authentication key of the MISP user
+		Key string
+		// 0 your organisation only, 1 this community only, 2 connected communities, 3 all communities
+		Distribution int
+		// skip the verification of the TLS certificate of the MISP instance
+		Insecure bool
+	}
+	// MISPExporter writes one MISP event per task to a file and/or pushes it through the REST API,
+	// events of re-crawled tasks are updated
+	MISPExporter struct {
+		config     *MISPExporterConfig
+		httpClient *http.Client
+	}
+)
+
+func NewMISPExporter(config *MISPExporterConfig) (*MISPExporter, error) {
+	if config.Dir != "" {
+		if err := os.MkdirAll(config.Dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create dir for saving: %s", err)
+		}
+	}
+	return &MISPExporter{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: time.Minute,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: config.Insecure},
+			},
+		},
+	}, nil
+}
+
+func (exporter *MISPExporter) Export(report *TaskReport) error {
+	event := NewMISPEvent(report, exporter.config.Distribution)
+	if exporter.config.Dir != "" {
+		bytes, err := json.MarshalIndent(event, "", " ")
+		if err != nil {
+			return fmt.Errorf("in MarshalIndent: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(exporter.config.Dir, report.UUID+".misp.json"), bytes, 0644); err != nil {
+			return fmt.Errorf("in WriteFile: %s", err)
+		}
+	}
+	if exporter.config.URL != "" {
+		if err := exporter.push(event); err != nil {
+			return fmt.Errorf("failed to push event '%s': %s", event.Event.UUID, err)
+		}
+	}
+	return nil
+}
+
+// push adds the event, or edits it when MISP tells it already exists
+func (exporter *MISPExporter) push(event *MISPEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("in Marshal: %s", err)
+	}
+	status, respBody, err := exporter.post("/events/add", body)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && strings.Contains(string(respBody), "already exists") {
+		status, respBody, err = exporter.post("/events/edit/"+event.Event.UUID, body)
+		if err != nil {
+			return err
+		}
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", status, respBody)
+	}
+	return nil
+}
+
+func (exporter *MISPExporter) post(path string, body []byte) (int, []byte, error) {
+	url := strings.TrimSuffix(exporter.config.URL, "/") + path
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, fmt.Errorf("in NewRequest: %s", err)
+	}
+	req.Header.Set("Authorization", exporter.config.Key)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := exporter.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("in Do: %s", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("in ReadAll: %s", err)
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+func (exporter *MISPExporter) Flush() error {
+	return nil
+}
+
+func (exporter *MISPExporter) Close() error {
+	return nil
+}
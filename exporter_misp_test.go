@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeMISPServer accepts an event once, then tells it already exists so that it is edited
+type fakeMISPServer struct {
+	paths  []string
+	events map[string]*MISPEvent
+}
+
+func (server *fakeMISPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "secret" {
+		http.Error(w, `{"message":"Authentication failed."}`, http.StatusForbidden)
+		return
+	}
+	server.paths = append(server.paths, r.URL.Path)
+	body, _ := ioutil.ReadAll(r.Body)
+	event := new(MISPEvent)
+	if err := json.Unmarshal(body, event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case "/events/add":
+		if server.events[event.Event.UUID] != nil {
+			http.Error(w, `{"name":"Event already exists.","errors":{"Event":{"uuid":"An event with this uuid already exists."}}}`, http.StatusForbidden)
+			return
+		}
+	case "/events/edit/" + event.Event.UUID:
+		if server.events[event.Event.UUID] == nil {
+			http.Error(w, `{"message":"Invalid event"}`, http.StatusNotFound)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	server.events[event.Event.UUID] = event
+	w.Write(body)
+}
+
+func TestMISPExporter(t *testing.T) {
+	server := &fakeMISPServer{events: make(map[string]*MISPEvent)}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	exporter, err := NewMISPExporter(&MISPExporterConfig{
+		Dir: t.TempDir(),
+		URL: httpServer.URL,
+		Key: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := newTestReport(t)
+	for i := 0; i < 2; i++ {
+		if err := exporter.Export(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"/events/add", "/events/add", "/events/edit/" + newUUIDv5(mispNamespace, report.UUID)}
+	if fmt.Sprint(server.paths) != fmt.Sprint(want) {
+		t.Fatalf("got requests %v, want %v", server.paths, want)
+	}
+
+	event := server.events[newUUIDv5(mispNamespace, report.UUID)].Event
+	tags := make(map[string]bool)
+	for _, tag := range event.Tag {
+		tags[tag.Name] = true
+	}
+	for _, name := range []string{"emotet", "trojan", "mitre-attack:T1055", "mitre-attack:T1059"} {
+		if !tags[name] {
+			t.Errorf("tag '%s' is missing from %v", name, tags)
+		}
+	}
+	if len(event.Galaxy) != 1 || event.Galaxy[0].Type != "mitre-attack-pattern" {
+		t.Fatalf("got galaxies %v, want the mitre-attack-pattern galaxy", event.Galaxy)
+	}
+	var clusters []string
+	for _, cluster := range event.Galaxy[0].GalaxyCluster {
+		clusters = append(clusters, cluster.TagName)
+	}
+	want = []string{
+		`misp-galaxy:mitre-attack-pattern="Process Injection - T1055"`,
+		`misp-galaxy:mitre-attack-pattern="Command and Scripting Interpreter - T1059"`,
+	}
+	if fmt.Sprint(clusters) != fmt.Sprint(want) {
+		t.Errorf("got clusters %v, want %v", clusters, want)
+	}
+	for _, attribute := range event.Attribute {
+		if attribute.Type == "text" && attribute.Category != "Other" {
+			t.Errorf("command line '%s' filed under '%s'", attribute.Value, attribute.Category)
+		}
+	}
+
+	exporter.config.Key = "wrong"
+	if err := exporter.Export(report); err == nil {
+		t.Error("pushed with a wrong key")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema normalizes task reports into tables keyed by the task uuid and the object ids of the documents.
+// Incidents, connections and HTTP requests reference their process by oid without a foreign key,
+// because the processes tab may not be exported.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS tasks (
+	uuid         TEXT PRIMARY KEY,
+	oid          TEXT NOT NULL UNIQUE,
+	date         TEXT,
+	type         TEXT,
+	run_type     TEXT,
+	name         TEXT,
+	url          TEXT,
+	md5          TEXT,
+	sha1         TEXT,
+	sha256       TEXT,
+	ssdeep       TEXT,
+	verdict      TEXT,
+	threat_level INTEGER,
+	significant  INTEGER,
+	crawled_at   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tasks_md5 ON tasks (md5);
+CREATE INDEX IF NOT EXISTS tasks_sha1 ON tasks (sha1);
+CREATE INDEX IF NOT EXISTS tasks_sha256 ON tasks (sha256);
+CREATE TABLE IF NOT EXISTS task_tags (
+	task_uuid TEXT NOT NULL REFERENCES tasks (uuid) ON DELETE CASCADE,
+	tag       TEXT NOT NULL,
+	PRIMARY KEY (task_uuid, tag)
+);
+CREATE TABLE IF NOT EXISTS processes (
+	oid              TEXT PRIMARY KEY,
+	task_uuid        TEXT NOT NULL REFERENCES tasks (uuid) ON DELETE CASCADE,
+	pid              INTEGER,
+	parent_pid       INTEGER,
+	image            TEXT,
+	cmd              TEXT,
+	user             TEXT,
+	integrity_level  TEXT,
+	important        INTEGER,
+	important_reason TEXT,
+	created          TEXT,
+	closed           TEXT
+);
+CREATE INDEX IF NOT EXISTS processes_task ON processes (task_uuid, pid);
+CREATE TABLE IF NOT EXISTS incidents (
+	oid          TEXT PRIMARY KEY,
+	task_uuid    TEXT NOT NULL REFERENCES tasks (uuid) ON DELETE CASCADE,
+	process_oid  TEXT,
+	threat_level INTEGER,
+	title        TEXT,
+	first_seen   TEXT
+);
+CREATE INDEX IF NOT EXISTS incidents_task ON incidents (task_uuid);
+CREATE TABLE IF NOT EXISTS incident_techniques (
+	incident_oid TEXT NOT NULL REFERENCES incidents (oid) ON DELETE CASCADE,
+	technique    TEXT NOT NULL,
+	PRIMARY KEY (incident_oid, technique)
+);
+CREATE INDEX IF NOT EXISTS incident_techniques_technique ON incident_techniques (technique);
+CREATE TABLE IF NOT EXISTS dns_queries (
+	oid        TEXT PRIMARY KEY,
+	task_uuid  TEXT NOT NULL REFERENCES tasks (uuid) ON DELETE CASCADE,
+	domain     TEXT,
+	reputation TEXT,
+	requested  TEXT
+);
+CREATE INDEX IF NOT EXISTS dns_queries_domain ON dns_queries (domain);
+CREATE TABLE IF NOT EXISTS dns_query_ips (
+	query_oid TEXT NOT NULL REFERENCES dns_queries (oid) ON DELETE CASCADE,
+	ip        TEXT NOT NULL,
+	PRIMARY KEY (query_oid, ip)
+);
+CREATE TABLE IF NOT EXISTS connections (
+	oid         TEXT PRIMARY KEY,
+	task_uuid   TEXT NOT NULL REFERENCES tasks (uuid) ON DELETE CASCADE,
+	process_oid TEXT,
+	pid         INTEGER,
+	protocol    TEXT,
+	src_ip      TEXT,
+	src_port    INTEGER,
+	dst_ip      TEXT,
+	dst_port    INTEGER,
+	country     TEXT,
+	asn         TEXT,
+	reputation  TEXT,
+	created     TEXT
+);
+CREATE INDEX IF NOT EXISTS connections_dst_ip ON connections (dst_ip);
+`
+
+// SQLiteExporter stores task reports into a SQLite database, re-crawled tasks are updated in place
+type SQLiteExporter struct {
+	db *sql.DB
+}
+
+func NewSQLiteExporter(path string) (*SQLiteExporter, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("in Open: %s", err)
+	}
+	// SQLite allows a single writer at a time
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %s", err)
+	}
+	return &SQLiteExporter{
+		db: db,
+	}, nil
+}
+
+// Export upserts the task and replaces all its documents in a single transaction, so that documents
+// which disappeared since the last crawl of the task are dropped.
+func (exporter *SQLiteExporter) Export(report *TaskReport) error {
+	tx, err := exporter.db.Begin()
+	if err != nil {
+		return fmt.Errorf("in Begin: %s", err)
+	}
+	if err := exporter.upsertReport(tx, report); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("in Commit: %s", err)
+	}
+	return nil
+}
+
+func (exporter *SQLiteExporter) upsertReport(tx *sql.Tx, report *TaskReport) error {
+	task := report.Task
+	mainObject := task.Fields.Public.Objects.MainObject
+	_, err := tx.Exec(`INSERT INTO tasks (uuid, oid, date, type, run_type, name, url, md5, sha1, sha256, ssdeep, verdict, threat_level, significant, crawled_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (uuid) DO UPDATE SET oid = excluded.oid, date = excluded.date, type = excluded.type, run_type = excluded.run_type,
+			name = excluded.name, url = excluded.url, md5 = excluded.md5, sha1 = excluded.sha1, sha256 = excluded.sha256, ssdeep = excluded.ssdeep,
+			verdict = excluded.verdict, threat_level = excluded.threat_level, significant = excluded.significant, crawled_at = excluded.crawled_at`,
+		report.UUID, task.ID, formatDate(task.Fields.Date.Date), mainObject.Type, task.Fields.Public.Objects.RunType,
+		mainObject.Names.Basename, mainObject.Names.URL, mainObject.Hashes.Md5, mainObject.Hashes.Sha1, mainObject.Hashes.Sha256, mainObject.Hashes.Ssdeep,
+		task.Fields.Scores.Verdict.Text, task.Fields.Scores.Verdict.ThreatLevel, task.Fields.Significant, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to upsert task: %s", err)
+	}
+	// techniques and resolved IPs are deleted along with their incident and query
+	for _, table := range []string{"task_tags", "processes", "incidents", "dns_queries", "connections"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE task_uuid = ?`, report.UUID); err != nil {
+			return fmt.Errorf("failed to delete %s: %s", table, err)
+		}
+	}
+	for _, tag := range task.Fields.Tags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO task_tags (task_uuid, tag) VALUES (?, ?)`, report.UUID, tag); err != nil {
+			return fmt.Errorf("failed to insert tag: %s", err)
+		}
+	}
+
+	for _, proc := range report.Processes {
+		_, err := tx.Exec(`INSERT INTO processes (oid, task_uuid, pid, parent_pid, image, cmd, user, integrity_level, important, important_reason, created, closed)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (oid) DO UPDATE SET task_uuid = excluded.task_uuid, pid = excluded.pid, parent_pid = excluded.parent_pid,
+				image = excluded.image, cmd = excluded.cmd, user = excluded.user, integrity_level = excluded.integrity_level,
+				important = excluded.important, important_reason = excluded.important_reason, created = excluded.created, closed = excluded.closed`,
+			proc.ID, report.UUID, proc.Fields.Pid, proc.Fields.ParentPID, proc.Fields.Image, proc.Fields.Cmd, proc.Fields.User.Name, proc.Fields.User.Il,
+			proc.Fields.Important, proc.Fields.Scores.ImportantReason, formatDate(proc.Fields.Times.Created.Date), formatDate(proc.Fields.Times.Closed.Date))
+		if err != nil {
+			return fmt.Errorf("failed to upsert process '%s': %s", proc.ID, err)
+		}
+	}
+
+	for _, incident := range report.Incidents {
+		_, err := tx.Exec(`INSERT INTO incidents (oid, task_uuid, process_oid, threat_level, title, first_seen)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (oid) DO UPDATE SET task_uuid = excluded.task_uuid, process_oid = excluded.process_oid,
+				threat_level = excluded.threat_level, title = excluded.title, first_seen = excluded.first_seen`,
+			incident.ID, report.UUID, incident.Fields.ProcessOID.Value, incident.Fields.Threatlevel, incident.Fields.Title, formatDate(incident.Fields.FirstSeen.Date))
+		if err != nil {
+			return fmt.Errorf("failed to upsert incident '%s': %s", incident.ID, err)
+		}
+		for _, technique := range incident.Fields.Mitre {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO incident_techniques (incident_oid, technique) VALUES (?, ?)`, incident.ID, technique); err != nil {
+				return fmt.Errorf("failed to insert technique: %s", err)
+			}
+		}
+	}
+
+	for _, query := range report.DNSQueries {
+		_, err := tx.Exec(`INSERT INTO dns_queries (oid, task_uuid, domain, reputation, requested)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (oid) DO UPDATE SET task_uuid = excluded.task_uuid, domain = excluded.domain,
+				reputation = excluded.reputation, requested = excluded.requested`,
+			query.ID, report.UUID, query.Fields.Domain, query.Fields.Reputation, formatDate(query.Fields.Times.Request.Date))
+		if err != nil {
+			return fmt.Errorf("failed to upsert DNS query '%s': %s", query.ID, err)
+		}
+		for _, ip := range query.Fields.IPs {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO dns_query_ips (query_oid, ip) VALUES (?, ?)`, query.ID, ip); err != nil {
+				return fmt.Errorf("failed to insert resolved IP: %s", err)
+			}
+		}
+	}
+
+	for _, connection := range report.Connections {
+		_, err := tx.Exec(`INSERT INTO connections (oid, task_uuid, process_oid, pid, protocol, src_ip, src_port, dst_ip, dst_port, country, asn, reputation, created)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (oid) DO UPDATE SET task_uuid = excluded.task_uuid, process_oid = excluded.process_oid, pid = excluded.pid,
+				protocol = excluded.protocol, src_ip = excluded.src_ip, src_port = excluded.src_port, dst_ip = excluded.dst_ip,
+				dst_port = excluded.dst_port, country = excluded.country, asn = excluded.asn, reputation = excluded.reputation, created = excluded.created`,
+			connection.ID, report.UUID, connection.Fields.ProcessOID.Value, connection.Fields.Pid, connection.Fields.Protocol,
+			connection.Fields.SrcIP, connection.Fields.SrcPort, connection.Fields.DstIP, connection.Fields.DstPort,
+			connection.Fields.Country, connection.Fields.ASN, connection.Fields.Reputation, formatDate(connection.Fields.Times.Created.Date))
+		if err != nil {
+			return fmt.Errorf("failed to upsert connection '%s': %s", connection.ID, err)
+		}
+	}
+	return nil
+}
+
+// Flush does nothing, each task is committed by Export
+func (exporter *SQLiteExporter) Flush() error {
+	return nil
+}
+
+func (exporter *SQLiteExporter) Close() error {
+	return exporter.db.Close()
+}
+
+// formatDate converts an EJSON $date in milliseconds to RFC 3339, NULL when the date is unset
+func formatDate(millis int64) interface{} {
+	if millis == 0 {
+		return nil
+	}
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+}
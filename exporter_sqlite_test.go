@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteExporter(t *testing.T) {
+	exporter, err := NewSQLiteExporter(filepath.Join(t.TempDir(), "crawl.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	report := newTestReport(t)
+	if err := exporter.Export(report); err != nil {
+		t.Fatal(err)
+	}
+	// documents which disappeared are dropped when the task is crawled again
+	report.Processes = report.Processes[:1]
+	report.Incidents = nil
+	report.DNSQueries[0].Fields.IPs = report.DNSQueries[0].Fields.IPs[:1]
+	report.Connections = nil
+	report.Task.Fields.Tags = []string{"emotet"}
+	if err := exporter.Export(report); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		table string
+		count int
+	}{
+		{"tasks", 1},
+		{"task_tags", 1},
+		{"processes", 1},
+		{"incidents", 0},
+		{"incident_techniques", 0},
+		{"dns_queries", 1},
+		{"dns_query_ips", 1},
+		{"connections", 0},
+	}
+	for _, test := range tests {
+		var count int
+		if err := exporter.db.QueryRow(`SELECT count(*) FROM ` + test.table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != test.count {
+			t.Errorf("%s: got %d rows, want %d", test.table, count, test.count)
+		}
+	}
+}
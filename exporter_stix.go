@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// STIXExporter writes STIX 2.1 bundles, either one file per task into a directory or a single file
+// for all tasks ever crawled, written once on close
+type STIXExporter struct {
+	dir  string
+	path string
+	// objects of the run bundle by id, in the order they were first seen
+	objects []STIXObject
+	index   map[string]int
+	changed bool
+}
+
+// stixRawObject is an object read back from an existing bundle
+type stixRawObject struct {
+	id  string
+	raw json.RawMessage
+}
+
+func (object *stixRawObject) GetID() string {
+	return object.id
+}
+
+func (object *stixRawObject) MarshalJSON() ([]byte, error) {
+	return object.raw, nil
+}
+
+// NewSTIXTaskExporter writes the bundle of each task to <dir>/<uuid>.stix.json
+func NewSTIXTaskExporter(dir string) (*STIXExporter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dir for saving: %s", err)
+	}
+	return &STIXExporter{
+		dir: dir,
+	}, nil
+}
+
+// NewSTIXRunExporter gathers the objects of all tasks into a single bundle written to the path. The objects
+// of an existing bundle are kept, so that resumed, incremental and watching crawls add up to the same file.
+func NewSTIXRunExporter(path string) (*STIXExporter, error) {
+	exporter := &STIXExporter{
+		path:  path,
+		index: make(map[string]int),
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return exporter, nil
+		}
+		return nil, fmt.Errorf("in ReadFile: %s", err)
+	}
+	var bundle struct {
+		Objects []json.RawMessage `json:"objects"`
+	}
+	if err := json.Unmarshal(bytes, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid bundle '%s': %s", path, err)
+	}
+	for _, raw := range bundle.Objects {
+		var common stixCommon
+		if err := json.Unmarshal(raw, &common); err != nil || common.ID == "" {
+			return nil, fmt.Errorf("invalid object in bundle '%s': %s", path, raw)
+		}
+		exporter.add(&stixRawObject{id: common.ID, raw: raw})
+	}
+	exporter.changed = false
+	return exporter, nil
+}
+
+// add appends the object to the run bundle, replacing the one with the same id
+func (exporter *STIXExporter) add(object STIXObject) {
+	exporter.changed = true
+	if i, ok := exporter.index[object.GetID()]; ok {
+		exporter.objects[i] = object
+		return
+	}
+	exporter.index[object.GetID()] = len(exporter.objects)
+	exporter.objects = append(exporter.objects, object)
+}
+
+func (exporter *STIXExporter) Export(report *TaskReport) error {
+	objects := NewSTIXObjects(report)
+	if exporter.dir != "" {
+		return writeSTIXBundle(filepath.Join(exporter.dir, report.UUID+".stix.json"), objects)
+	}
+	for _, object := range objects {
+		exporter.add(object)
+	}
+	return nil
+}
+
+// Flush does nothing, the run bundle is kept in memory until Close since writing it is not incremental
+func (exporter *STIXExporter) Flush() error {
+	return nil
+}
+
+// Close writes the run bundle if tasks were exported. It is only lost if the process is killed,
+// the checkpoint file then records tasks missing from the bundle.
+func (exporter *STIXExporter) Close() error {
+	if exporter.path == "" || !exporter.changed {
+		return nil
+	}
+	if err := writeSTIXBundle(exporter.path, exporter.objects); err != nil {
+		return err
+	}
+	exporter.changed = false
+	return nil
+}
+
+// writeSTIXBundle replaces the file at once, so that an interrupted write leaves the previous bundle intact
+func writeSTIXBundle(path string, objects []STIXObject) error {
+	bytes, err := json.MarshalIndent(NewSTIXBundle(objects), "", " ")
+	if err != nil {
+		return fmt.Errorf("in MarshalIndent: %s", err)
+	}
+	if err := ioutil.WriteFile(path+".tmp", bytes, 0644); err != nil {
+		return fmt.Errorf("in WriteFile: %s", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("in Rename: %s", err)
+	}
+	return nil
+}
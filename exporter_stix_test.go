@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// getSTIXObjectsByType maps the objects to their JSON form, grouped by type
+func getSTIXObjectsByType(t *testing.T, objects []STIXObject) map[string][]map[string]interface{} {
+	byType := make(map[string][]map[string]interface{})
+	for _, object := range objects {
+		buffer, err := json.Marshal(object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(buffer, &fields); err != nil {
+			t.Fatal(err)
+		}
+		objectType := fields["type"].(string)
+		byType[objectType] = append(byType[objectType], fields)
+	}
+	return byType
+}
+
+func TestNewSTIXObjects(t *testing.T) {
+	report := newTestReport(t)
+	byType := getSTIXObjectsByType(t, NewSTIXObjects(report))
+	// the id of a file is derived from a single hash, as the reference implementation does
+	if id := byType["file"][0]["id"]; id != "file--863f7a1b-035b-51dd-b95f-991a67816c85" {
+		t.Errorf("got file id %s", id)
+	}
+	if len(byType["indicator"]) != 1 || len(byType["attack-pattern"]) != 2 {
+		t.Fatalf("got %d indicators and %d attack patterns", len(byType["indicator"]), len(byType["attack-pattern"]))
+	}
+	if name := byType["malware-analysis"][0]["result_name"]; name != "emotet" {
+		t.Errorf("got result name %v, want the first tag", name)
+	}
+	for _, object := range append(byType["attack-pattern"], byType["malware"]...) {
+		if object["created"] != stixSharedTimestamp || object["modified"] != stixSharedTimestamp {
+			t.Errorf("shared object %s is dated %s", object["id"], object["created"])
+		}
+	}
+
+	// tasks without threats keep their techniques, related to the analysis
+	report.Task.Fields.Scores.Verdict.ThreatLevel = 0
+	byType = getSTIXObjectsByType(t, NewSTIXObjects(report))
+	if len(byType["indicator"]) != 0 || len(byType["attack-pattern"]) != 2 {
+		t.Fatalf("got %d indicators and %d attack patterns", len(byType["indicator"]), len(byType["attack-pattern"]))
+	}
+	analysisID := byType["malware-analysis"][0]["id"]
+	numOfRelated := 0
+	for _, relationship := range byType["relationship"] {
+		if relationship["relationship_type"] == "related-to" && relationship["source_ref"] == analysisID {
+			numOfRelated++
+		}
+	}
+	if numOfRelated != 2 {
+		t.Errorf("got %d attack patterns related to the analysis, want 2", numOfRelated)
+	}
+}
+
+func TestSTIXRunExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.stix.json")
+	first := newTestReport(t)
+	second := newTestReport(t)
+	second.UUID = "5e1c8e5a-6a3a-4d5e-9e43-8b2b1c9a2f10"
+	second.Task.Fields.UUID = second.UUID
+
+	// each run adds its tasks to the bundle of the previous runs
+	var numOfObjects int
+	for i, report := range []*TaskReport{first, second, first} {
+		exporter, err := NewSTIXRunExporter(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exporter.Export(report); err != nil {
+			t.Fatal(err)
+		}
+		if err := exporter.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		// the bundle is only written on close
+		if _, err := os.Stat(path); i == 0 && !os.IsNotExist(err) {
+			t.Fatalf("the bundle is written before close")
+		}
+		if err := exporter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		numOfObjects = len(exporter.objects)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle struct {
+		Objects []stixCommon `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	numOfAnalyses := 0
+	for _, object := range bundle.Objects {
+		if ids[object.ID] {
+			t.Errorf("object %s is duplicated", object.ID)
+		}
+		ids[object.ID] = true
+		if object.Type == "malware-analysis" {
+			numOfAnalyses++
+		}
+	}
+	if numOfAnalyses != 2 || len(bundle.Objects) != numOfObjects {
+		t.Errorf("got %d analyses and %d objects, want 2 and %d", numOfAnalyses, len(bundle.Objects), numOfObjects)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+// testReport is a malicious file task with each tab filled
+const testReport = `{
+	"uuid": "0cf223f2-530e-4a50-8e6b-76c6d8cdb3e4",
+	"task": {"id": "task-oid", "fields": {
+		"uuid": "0cf223f2-530e-4a50-8e6b-76c6d8cdb3e4",
+		"date": {"$date": 1600000000000},
+		"tags": ["emotet", "trojan"],
+		"scores": {"verdict": {"threat_level": 2, "text": "Malicious activity"}},
+		"public": {"objects": {"runType": "file", "mainObject": {
+			"type": "file",
+			"names": {"basename": "invoice.doc"},
+			"hashes": {"md5": "d41d8cd98f00b204e9800998ecf8427e", "sha1": "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+				"sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}
+		}}}
+	}},
+	"processes": [
+		{"id": "p1", "fields": {"pid": 100, "parentPID": 1, "image": "C:\\Windows\\winword.exe", "cmd": "winword.exe /n invoice.doc",
+			"scores": {"important_reason": "main object", "specs": {"injects": true}}}},
+		{"id": "p2", "fields": {"pid": 101, "parentPID": 100, "image": "C:\\Windows\\cmd.exe", "cmd": "=cmd /c calc"}}
+	],
+	"incidents": [
+		{"id": "i1", "fields": {"title": "Injects into other processes", "threatlevel": 2, "mitre": ["T1055", "T1059"],
+			"processOID": {"$type": "oid", "$value": "p1"}}}
+	],
+	"dns_queries": [
+		{"id": "d1", "fields": {"domain": "evil.example", "ips": ["203.0.113.1", "203.0.113.2"]}}
+	],
+	"connections": [
+		{"id": "c1", "fields": {"pid": 100, "protocol": "tcp", "dstIP": "203.0.113.1", "dstPort": 443,
+			"processOID": {"$type": "oid", "$value": "p1"}}}
+	]
+}`
+
+func newTestReport(t *testing.T) *TaskReport {
+	report := new(TaskReport)
+	if err := json.Unmarshal([]byte(testReport), report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestJSONFileExporter(t *testing.T) {
+	exporter, err := NewJSONFileExporter(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := newTestReport(t)
+	if err := exporter.Export(report); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(exporter.GetFilePath(report.UUID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := new(TaskReport)
+	if err := json.Unmarshal(data, saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.UUID != report.UUID || len(saved.Processes) != 2 || len(saved.Incidents) != 1 {
+		t.Fatalf("saved task '%s' with %d processes and %d incidents, want '%s' with 2 and 1",
+			saved.UUID, len(saved.Processes), len(saved.Incidents), report.UUID)
+	}
+	if mitre := saved.Incidents[0].Fields.Mitre; len(mitre) != 2 || mitre[0] != "T1055" {
+		t.Errorf("saved incident techniques %v, want [T1055 T1059]", mitre)
+	}
+}
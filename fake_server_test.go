@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeServer serves the public tasks, processes and incidents subscriptions over SockJS.
+// Tasks are listed newest-first, the task with the highest serial is the newest one.
+type fakeServer struct {
+	mu         sync.Mutex
+	numOfTasks int
+	// the messages of a reply are sent in a single frame instead of a frame per message
+	batched bool
+	// the number of subscriptions left unanswered before answering them, by name
+	stuck map[string]int
+	// the connection is dropped when the n-th subscription is received, 0 means never
+	dropOnSub int
+	numOfSubs int
+	// the documents of a collection are added to another one, by collection name
+	renamed map[string]string
+	// the number of pongs replied to the ping sent along with "connected"
+	numOfPongs int
+	// the Origin header of the last websocket request
+	origin string
+	// the handshake is answered with "failed" proposing this version, empty means it succeeds
+	failedVersion string
+	// subscriptions answered with "nosub" or "error" instead of their documents, by name
+	rejected map[string]string
+	// the task filters sent with publicTasks and publicTasksCounter, in order
+	taskParams []string
+}
+
+func (server *fakeServer) setNumOfTasks(numOfTasks int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.numOfTasks = numOfTasks
+}
+
+func (server *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/info") {
+		w.Write([]byte(`{"websocket":true,"origins":["*:*"],"cookie_needed":false,"entropy":1}`))
+		return
+	}
+	server.mu.Lock()
+	server.origin = r.Header.Get("Origin")
+	server.mu.Unlock()
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var writeMu sync.Mutex
+	write := func(data []byte) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.WriteMessage(websocket.TextMessage, data)
+	}
+	send := func(msgs ...string) {
+		if server.batched {
+			write(newFakeFrame(msgs...))
+			return
+		}
+		for _, msg := range msgs {
+			write(newFakeFrame(msg))
+		}
+	}
+	write([]byte("o"))
+	send(`{"server_id":"0"}`)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msgs []string
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			return
+		}
+		for _, data := range msgs {
+			var msg struct {
+				Msg    string            `json:"msg"`
+				ID     string            `json:"id"`
+				Name   string            `json:"name"`
+				Params []json.RawMessage `json:"params"`
+			}
+			json.Unmarshal([]byte(data), &msg)
+			switch msg.Msg {
+			case "connect":
+				if server.failedVersion != "" {
+					write(newFakeFrame(fmt.Sprintf(`{"msg":"failed","version":"%s"}`, server.failedVersion)))
+					continue
+				}
+				write(newFakeFrame(`{"msg":"connected","session":"0"}`, `{"msg":"ping"}`))
+			case "method":
+				server.mu.Lock()
+				numOfTasks := server.numOfTasks
+				if len(msg.Params) > 0 {
+					server.taskParams = append(server.taskParams, string(msg.Params[0]))
+				}
+				server.mu.Unlock()
+				send(fmt.Sprintf(`{"msg":"result","id":"%s","result":{"count":%d}}`, msg.ID, numOfTasks))
+			case "pong":
+				server.mu.Lock()
+				server.numOfPongs++
+				server.mu.Unlock()
+			case "unsub":
+				send(fmt.Sprintf(`{"msg":"nosub","id":"%s"}`, msg.ID))
+			case "sub":
+				server.mu.Lock()
+				server.numOfSubs++
+				drop := server.numOfSubs == server.dropOnSub
+				stuck := server.stuck[msg.Name] > 0
+				if stuck {
+					server.stuck[msg.Name]--
+				}
+				server.mu.Unlock()
+				if drop {
+					return
+				}
+				if stuck {
+					continue
+				}
+				switch server.rejected[msg.Name] {
+				case "nosub":
+					send(fmt.Sprintf(`{"msg":"nosub","id":"%s","error":{"error":404,"reason":"Subscription '%s' not found"}}`, msg.ID, msg.Name))
+					continue
+				case "error":
+					send(fmt.Sprintf(`{"msg":"error","reason":"Match failed","offendingMessage":%s}`, data))
+					continue
+				}
+				docs := server.getDocuments(msg.Name, msg.Params)
+				for from, to := range server.renamed {
+					for i := range docs {
+						docs[i] = strings.Replace(docs[i], `"collection":"`+from+`"`, `"collection":"`+to+`"`, 1)
+					}
+				}
+				go send(append(docs, fmt.Sprintf(`{"msg":"ready","subs":["%s"]}`, msg.ID))...)
+			}
+		}
+	}
+}
+
+// getDocuments returns the "added" messages of the subscription
+func (server *fakeServer) getDocuments(name string, params []json.RawMessage) []string {
+	var msgs []string
+	switch name {
+	case "publicTasks":
+		var count, skip int
+		json.Unmarshal(params[0], &count)
+		json.Unmarshal(params[1], &skip)
+		server.mu.Lock()
+		numOfTasks := server.numOfTasks
+		server.taskParams = append(server.taskParams, string(params[2]))
+		server.mu.Unlock()
+		for index := skip; index < skip+count && index < numOfTasks; index++ {
+			serial := numOfTasks - 1 - index
+			msgs = append(msgs, newFakeTask(serial))
+		}
+	case "process":
+		taskID := getFakeTaskID(params[0])
+		for i := 0; i < 2; i++ {
+			msgs = append(msgs, fmt.Sprintf(`{"msg":"added","collection":"processes","id":"%s-p%d","fields":{"pid":%d,"image":"a.exe","task":{"$type":"oid","$value":"%[1]s"}}}`, taskID, i, 100+i))
+		}
+	case "allIncidents":
+		var param struct {
+			Value string `json:"$value"`
+		}
+		json.Unmarshal(params[0], &param)
+		msgs = append(msgs, fmt.Sprintf(`{"msg":"added","collection":"incidents","id":"%s-i0","fields":{"title":"Injects","threatlevel":2,"mitre":["T1055"],"task":{"$type":"oid","$value":"%[1]s"}}}`, param.Value))
+	case "dnsQueries":
+		msgs = append(msgs, fmt.Sprintf(`{"msg":"added","collection":"dnsQueries","id":"%s-d0","fields":{"task":{"$type":"oid","$value":"%[1]s"},"domain":"evil.example","ips":["203.0.113.1","203.0.113.2"],"reputation":"malicious","times":{"request":{"$date":1600000000000},"response":{"$date":1600000000050}}}}`, getFakeTaskID(params[0])))
+	case "connections":
+		msgs = append(msgs, fmt.Sprintf(`{"msg":"added","collection":"connections","id":"%s-c0","fields":{"task":{"$type":"oid","$value":"%[1]s"},"processOID":{"$type":"oid","$value":"%[1]s-p0"},"pid":100,"protocol":"tcp","srcIP":"192.168.100.10","srcPort":49152,"dstIP":"203.0.113.1","dstPort":443,"country":"NL","asn":"AS64496","reputation":"suspicious","times":{"created":{"$date":1600000000100},"closed":{"$date":1600000000900}}}}`, getFakeTaskID(params[0])))
+	case "httpRequests":
+		msgs = append(msgs, fmt.Sprintf(`{"msg":"added","collection":"httpRequests","id":"%s-h0","fields":{"task":{"$type":"oid","$value":"%[1]s"},"processOID":{"$type":"oid","$value":"%[1]s-p0"},"pid":100,"method":"POST","url":"http://evil.example/gate.php","host":"evil.example","ip":"203.0.113.1","port":80,"userAgent":"Mozilla/4.0","reputation":"malicious","response":{"status":200,"contentType":"text/html","size":1024},"times":{"request":{"$date":1600000000200},"response":{"$date":1600000000300}}}}`, getFakeTaskID(params[0])))
+	case "taskexists":
+		var taskUuid string
+		json.Unmarshal(params[0], &taskUuid)
+		if serial, ok := server.getSerial(taskUuid, "task-"); ok {
+			msgs = append(msgs, fmt.Sprintf(`{"msg":"added","collection":"taskexists","id":"%s","fields":{"taskId":"%[1]s","taskObjectId":{"$type":"oid","$value":"oid-%d"}}}`, taskUuid, serial))
+		}
+	case "singleTask":
+		var param struct {
+			Value string `json:"$value"`
+		}
+		json.Unmarshal(params[0], &param)
+		if serial, ok := server.getSerial(param.Value, "oid-"); ok {
+			msgs = append(msgs, newFakeTask(serial))
+		}
+	}
+	return msgs
+}
+
+// getSerial parses the serial of an existing task out of its uuid or object id
+func (server *fakeServer) getSerial(id, prefix string) (int, bool) {
+	var serial int
+	if _, err := fmt.Sscanf(id, prefix+"%d", &serial); err != nil {
+		return 0, false
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return serial, serial >= 0 && serial < server.numOfTasks
+}
+
+// newFakeTask returns the "added" message of the task with the serial
+func newFakeTask(serial int) string {
+	return fmt.Sprintf(`{"msg":"added","collection":"tasks","id":"oid-%d","fields":{"uuid":"task-%d","date":{"$date":%d},"tags":["emotet"]}}`, serial, serial, 1000+serial)
+}
+
+// getFakeTaskID returns the task id of the {"taskID": ObjectID} parameter
+func getFakeTaskID(param json.RawMessage) string {
+	var filter struct {
+		TaskID struct {
+			Value string `json:"$value"`
+		} `json:"taskID"`
+	}
+	json.Unmarshal(param, &filter)
+	return filter.TaskID.Value
+}
+
+func newFakeFrame(msgs ...string) []byte {
+	frame, err := json.Marshal(msgs)
+	if err != nil {
+		panic(err)
+	}
+	return append([]byte("a"), frame...)
+}
+
+// newFakeClient starts the server and returns a client connected to it
+func newFakeClient(t *testing.T, server *fakeServer, appConfig *AppConfig) *AppAnyClient {
+	client := dialFakeServer(t, server, appConfig)
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// dialFakeServer starts the server and returns a client whose handshake is not done yet
+func dialFakeServer(t *testing.T, server *fakeServer, appConfig *AppConfig) *AppAnyClient {
+	httpServer := httptest.NewTLSServer(server)
+	t.Cleanup(httpServer.Close)
+	websocket.DefaultDialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	client, err := NewAppAnyClient(&AppAnyClientConfig{
+		Hosts:      []string{strings.TrimPrefix(httpServer.URL, "https://")},
+		ReqHeader:  http.Header{},
+		AppConfig:  appConfig,
+		MaxRetries: 3,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
@@ -4,6 +4,7 @@ go 1.15
 
 require (
 	github.com/gorilla/websocket v1.4.2
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/prometheus/common v0.4.0
 	github.com/rs/zerolog v1.20.0
 	github.com/spf13/viper v1.7.1
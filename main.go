@@ -1,27 +1,54 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
 )
 
 var (
 	configFilePath string
 	startTaskIndex uint
 	numOfTasks     uint
+	taskUUID       string
+	outputPath     string
+	dbPath         string
+	bulkPath       string
+	stixDir        string
+	stixPath       string
+	mispDir        string
+	csvDir         string
+	statePath      string
+	resume         bool
+	incremental    bool
+	watch          bool
+	workers        int
 )
 
+// taskFilterFlags maps the flags overriding the public task filter to their configuration keys
+var taskFilterFlags = map[string]string{
+	"hash":      "public_tasks.hash",
+	"file-hash": "public_tasks.file_hash",
+	"ip":        "public_tasks.ip",
+	"domain":    "public_tasks.domain",
+	"mitre":     "public_tasks.mitre",
+	"runtype":   "public_tasks.runtype",
+}
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 	log.Logger = log.Output(zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+		Out:        os.Stderr,
 		TimeFormat: time.RFC3339,
 	})
 	flag.StringVar(&configFilePath, "c", "config.yml", "the `configuration` file")
@@ -30,30 +57,68 @@ func init() {
 	flag.UintVar(&startTaskIndex, "index", 0, "crawl tasks start from the `index`")
 	flag.UintVar(&numOfTasks, "n", 0, "number of tasks to crawl")
 	flag.UintVar(&numOfTasks, "number", 0, "number of tasks to crawl")
-}
-
-// app.any.run endpoint
-var endpointList = [...]string{
-	"wss://app.any.run/sockjs/399/i73_d8dy/websocket",
-	"wss://app.any.run/sockjs/529/c95zff_m/websocket",
+	flag.StringVar(&statePath, "s", "crawler_state.json", "the checkpoint `file` recording the crawl progress")
+	flag.StringVar(&statePath, "state", "crawler_state.json", "the checkpoint `file` recording the crawl progress")
+	flag.BoolVar(&resume, "resume", false, "continue the last interrupted crawl recorded in the checkpoint file, skipping exported tasks")
+	flag.BoolVar(&incremental, "incremental", false, "only crawl tasks submitted since the last incremental crawl recorded in the checkpoint file, at most -n (default 1000) tasks")
+	flag.BoolVar(&watch, "watch", false, "keep polling for new tasks, at most -n (default 50) tasks are crawled per poll")
+	flag.IntVar(&workers, "workers", 1, "number of tasks enriched concurrently")
+	flag.StringVar(&taskUUID, "u", "", "only crawl the task with the `uuid` as in https://app.any.run/tasks/<uuid>")
+	flag.StringVar(&taskUUID, "uuid", "", "only crawl the task with the `uuid` as in https://app.any.run/tasks/<uuid>")
+	flag.StringVar(&outputPath, "o", "", "also stream one JSON record per task to the `file`, \"-\" means stdout")
+	flag.StringVar(&outputPath, "output", "", "also stream one JSON record per task to the `file`, \"-\" means stdout")
+	flag.StringVar(&dbPath, "db", "", "also store tasks into the SQLite database `file`, e.g. crawl.sqlite, it needs a build with cgo")
+	flag.StringVar(&bulkPath, "bulk", "", "also append ECS documents of tasks as _bulk requests to the NDJSON `file`, see the ecs section of the configuration")
+	flag.StringVar(&stixDir, "stix", "", "also write a STIX 2.1 bundle per task into the `directory`")
+	flag.StringVar(&stixPath, "stix-bundle", "", "also write a single STIX 2.1 bundle of all crawled tasks to the `file` on exit, merged into the existing bundle")
+	flag.StringVar(&mispDir, "misp", "", "also write a MISP event per task into the `directory`, see the misp section of the configuration to push them")
+	flag.StringVar(&csvDir, "csv", "", "also append tasks, processes and incidents to tasks.csv, processes.csv and incidents.csv in the `directory`")
+	flag.String("hash", "", "only crawl tasks with an object of the `hash`, overrides public_tasks.hash")
+	flag.String("file-hash", "", "only crawl tasks whose submitted file has the `hash`, overrides public_tasks.file_hash")
+	flag.String("ip", "", "only crawl tasks which contacted the `ip`, overrides public_tasks.ip")
+	flag.String("domain", "", "only crawl tasks which resolved the `domain`, overrides public_tasks.domain")
+	flag.String("mitre", "", "only crawl tasks matching the ATT&CK `technique` e.g. T1055, overrides public_tasks.mitre")
+	flag.String("runtype", "", "only crawl tasks of the run `types` separated by a comma e.g. file,url, overrides public_tasks.runtype")
 }
 
 func main() {
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if key, ok := taskFilterFlags[f.Name]; ok {
+			viper.Set(key, f.Value.String())
+		}
+	})
 	appConfig, err := ReadAppConfig(configFilePath)
 	if err != nil {
 		log.Fatal().Err(err).Msgf("failed to parse configuration file '%s'", configFilePath)
 	}
 
+	if workers < 1 {
+		log.Fatal().Msgf("invalid number of workers (%d): must be at least 1", workers)
+	}
+
 	reqHeader := make(http.Header)
-	reqHeader.Add("Host", "app.any.run")
 	reqHeader.Add("User-Agent", "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:82.0) Gecko/20100101 Firefox/82.0")
-	reqHeader.Add("Origin", "https://app.any.run")
 
 	config := &AppAnyClientConfig{
-		Endpoint:  endpointList[rand.Intn(len(endpointList))],
-		ReqHeader: reqHeader,
-		AppConfig: appConfig,
+		Hosts:       appConfig.connHosts,
+		ProbeInfo:   appConfig.connProbeInfo,
+		ReqHeader:   reqHeader,
+		AppConfig:   appConfig,
+		MaxRetries:  appConfig.connMaxRetries,
+		MinBackoff:  appConfig.connMinBackoff,
+		MaxBackoff:  appConfig.connMaxBackoff,
+		ReadTimeout: appConfig.connReadTimeout,
+		ReqTimeout:  appConfig.connReqTimeout,
+
+		RateLimiter:       NewRateLimiter(appConfig.rateLimitRPS, appConfig.rateLimitBurst),
+		GlobalRateLimiter: NewRateLimiter(appConfig.rateLimitGlobalRPS, appConfig.rateLimitGlobalBurst),
+	}
+	if watch {
+		// for troubleshooting only, the exported documents already include the changes received before ready
+		config.OnChange = func(event *DocumentEvent) {
+			log.Debug().Msgf("document %s: %s/%s", event.Type, event.Collection, event.ID)
+		}
 	}
 	client, err := NewAppAnyClient(config)
 	if err != nil {
@@ -64,13 +129,158 @@ func main() {
 	}
 	log.Info().Msg("connected to App.Any.Run")
 
-	totalTaskCount, err := client.GetNumOfTasks()
+	fileExporter, err := NewJSONFileExporter(appConfig.exportDir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("in NewJSONFileExporter")
+	}
+	exporter := Exporters{fileExporter}
+	if outputPath != "" {
+		jsonlExporter, err := NewJSONLExporter(outputPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("in NewJSONLExporter")
+		}
+		exporter = append(exporter, jsonlExporter)
+	}
+	if dbPath != "" {
+		sqliteExporter, err := NewSQLiteExporter(dbPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("in NewSQLiteExporter")
+		}
+		exporter = append(exporter, sqliteExporter)
+	}
+	if bulkPath != "" || appConfig.ecsEndpoint != "" {
+		ecsExporter, err := NewECSBulkExporter(&ECSBulkExporterConfig{
+			Index:         appConfig.ecsIndex,
+			FilePath:      bulkPath,
+			Endpoint:      appConfig.ecsEndpoint,
+			Username:      appConfig.ecsUsername,
+			Password:      appConfig.ecsPassword,
+			BatchSize:     appConfig.ecsBatchSize,
+			FlushInterval: appConfig.ecsFlushInterval,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("in NewECSBulkExporter")
+		}
+		exporter = append(exporter, ecsExporter)
+	}
+	if csvDir != "" {
+		csvExporter, err := NewCSVExporter(&CSVExporterConfig{
+			Dir:             csvDir,
+			Delimiter:       appConfig.csvDelimiter,
+			TaskColumns:     appConfig.csvTaskColumns,
+			ProcessColumns:  appConfig.csvProcessColumns,
+			IncidentColumns: appConfig.csvIncidentColumns,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("in NewCSVExporter")
+		}
+		exporter = append(exporter, csvExporter)
+	}
+	if mispDir != "" || appConfig.mispURL != "" {
+		mispExporter, err := NewMISPExporter(&MISPExporterConfig{
+			Dir:          mispDir,
+			URL:          appConfig.mispURL,
+			Key:          appConfig.mispKey,
+			Distribution: appConfig.mispDistribution,
+			Insecure:     appConfig.mispInsecure,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("in NewMISPExporter")
+		}
+		exporter = append(exporter, mispExporter)
+	}
+	if stixDir != "" {
+		stixExporter, err := NewSTIXTaskExporter(stixDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("in NewSTIXTaskExporter")
+		}
+		exporter = append(exporter, stixExporter)
+	}
+	if stixPath != "" {
+		stixExporter, err := NewSTIXRunExporter(stixPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("in NewSTIXRunExporter")
+		}
+		exporter = append(exporter, stixExporter)
+	}
+	state, err := LoadCrawlState(statePath)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to load checkpoint file '%s'", statePath)
+	}
+	crawler := NewCrawler(client, appConfig, exporter, state)
+	crawler.Workers = workers
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Warn().Msgf("received %s, stopping", sig)
+		cancel()
+	}()
+
+	err = crawl(ctx, client, crawler, state, appConfig)
+	// flushed and written on every exit path, including SIGINT. Tasks which failed to be flushed must not be
+	// recorded as exported, the checkpoint file is then left as of the last successful flush.
+	if err := exporter.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to close exporters")
+	} else if err := state.Save(); err != nil {
+		log.Error().Err(err).Msgf("failed to write checkpoint file '%s'", statePath)
+	}
+	if ctx.Err() != nil {
+		log.Warn().Msgf("interrupted, use -resume to continue from checkpoint file '%s'", statePath)
+		os.Exit(130)
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("in crawl")
+	}
+}
+
+// crawl runs the mode selected by the flags
+func crawl(ctx context.Context, client *AppAnyClient, crawler *Crawler, state *CrawlState, appConfig *AppConfig) error {
+	if taskUUID != "" {
+		task, err := client.GetTaskByUUIDCtx(ctx, taskUUID)
+		if err != nil {
+			return fmt.Errorf("failed to get task '%s': %w", taskUUID, err)
+		}
+		log.Info().Msg(task.GetIdentity())
+		return crawler.CrawlTask(ctx, task)
+	}
+
+	totalTaskCount, err := client.GetNumOfTasksCtx(ctx)
 	if err != nil {
-		log.Fatal().Err(err).Msg("in GetNumOfTasks")
+		return fmt.Errorf("in GetNumOfTasks: %s", err)
 	}
 	log.Info().Msgf("Number of possible tasks: %d", totalTaskCount)
+	if watch {
+		if numOfTasks == 0 {
+			numOfTasks = MaxTasksPerPage
+		}
+		crawler.SkipExported = true
+		log.Info().Msgf("Start watching new tasks every %s", appConfig.watchInterval)
+		return crawler.Watch(ctx, appConfig.watchInterval, numOfTasks)
+	}
+	if incremental {
+		if numOfTasks == 0 {
+			numOfTasks = MaxNewTasksPerCrawl
+		}
+		crawler.SkipExported = true
+		log.Info().Msgf("Start crawling new tasks since '%s'", state.Newest.UUID)
+		count, err := crawler.CrawlNewTasks(ctx, numOfTasks)
+		log.Info().Msgf("crawled %d new tasks", count)
+		return err
+	}
+	if resume {
+		if !state.CanResume() {
+			return fmt.Errorf("nothing to resume in checkpoint file '%s'", statePath)
+		}
+		startTaskIndex = state.NextIndex
+		numOfTasks = state.EndIndex - state.NextIndex
+		crawler.SkipExported = true
+		log.Info().Msgf("resuming the last crawl from index %d", startTaskIndex)
+	}
 	if startTaskIndex >= totalTaskCount {
-		log.Fatal().Msgf("the requested start index (%d) must not be less than number of tasks available (%d)", startTaskIndex, totalTaskCount)
+		return fmt.Errorf("the requested start index (%d) must not be less than number of tasks available (%d)", startTaskIndex, totalTaskCount)
 	}
 	if numOfTasks == 0 {
 		numOfTasks = totalTaskCount - startTaskIndex
@@ -80,26 +290,8 @@ func main() {
 		log.Warn().Msgf("only able to crawl %d tasks", numOfTasks)
 	}
 	log.Info().Msgf("Start crawling tasks (number %d, startIndex: %d)", numOfTasks, startTaskIndex)
-	tasks, err := client.GetTasks(int(numOfTasks), int(startTaskIndex))
-	if err != nil {
-		log.Fatal().Err(err).Msg("in GetProcesses")
-	}
-	for _, task := range tasks {
-		fmt.Print("\n\n")
-		log.Info().Msg(task.GetIdentity())
-		processes, err := client.GetProcesses(task)
-		if err != nil {
-			log.Fatal().Err(err).Msgf("failed to get processes")
-		}
-		incidents, err := client.GetIncidents(task)
-		if err != nil {
-			log.Fatal().Err(err).Msgf("failed to get incidents")
-		}
-		for _, proc := range processes {
-			log.Info().Msgf("[PROCESS] %d - %s - %s", proc.Fields.Pid, proc.Fields.Scores.ImportantReason, proc.Fields.Image)
-		}
-		for _, incident := range incidents {
-			log.Info().Msgf("[MITRE ATT&CK] %s, %v", incident.Fields.Title, incident.Fields.Mitre)
-		}
+	if resume {
+		return crawler.ResumeTasks(ctx, numOfTasks, startTaskIndex)
 	}
+	return crawler.CrawlTasks(ctx, numOfTasks, startTaskIndex)
 }
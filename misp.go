@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// mispNamespace is the UUIDv5 namespace of the events and attributes, so that re-crawled tasks keep their uuids
+var mispNamespace = [16]byte{0x47, 0x8e, 0x29, 0x4d, 0xcf, 0x6d, 0x4c, 0xa4, 0xaa, 0xec, 0x97, 0xcd, 0x91, 0x22, 0xc7, 0x6b}
+
+type (
+	// MISPEvent is the JSON form of an event as accepted by /events/add and the JSON import
+	MISPEvent struct {
+		Event *MISPEventBody `json:"Event"`
+	}
+	MISPEventBody struct {
+		UUID          string           `json:"uuid"`
+		Info          string           `json:"info"`
+		Date          string           `json:"date"`
+		ThreatLevelID string           `json:"threat_level_id"`
+		Analysis      string           `json:"analysis"`
+		Distribution  string           `json:"distribution"`
+		Attribute     []*MISPAttribute `json:"Attribute"`
+		Tag           []*MISPTag       `json:"Tag"`
+		Galaxy        []*MISPGalaxy    `json:"Galaxy,omitempty"`
+	}
+	MISPAttribute struct {
+		UUID     string `json:"uuid"`
+		Type     string `json:"type"`
+		Category string `json:"category"`
+		Value    string `json:"value"`
+		ToIDs    bool   `json:"to_ids"`
+		Comment  string `json:"comment,omitempty"`
+	}
+	MISPTag struct {
+		Name string `json:"name"`
+	}
+	MISPGalaxy struct {
+		Type          string               `json:"type"`
+		Name          string               `json:"name"`
+		Namespace     string               `json:"namespace"`
+		GalaxyCluster []*MISPGalaxyCluster `json:"GalaxyCluster"`
+	}
+	MISPGalaxyCluster struct {
+		Type    string              `json:"type"`
+		Value   string              `json:"value"`
+		TagName string              `json:"tag_name"`
+		Meta    map[string][]string `json:"meta"`
+	}
+)
+
+// NewMISPEvent maps the task to an event whose attributes are the sample, the command lines and the network IOCs.
+// Malware tags and techniques become tags, the latter of the mitre-attack taxonomy e.g. "mitre-attack:T1055",
+// and techniques are also attached as clusters of the mitre-attack-pattern galaxy.
+func NewMISPEvent(report *TaskReport, distribution int) *MISPEvent {
+	task := report.Task
+	mainObject := task.Fields.Public.Objects.MainObject
+	date := time.Now()
+	if task.Fields.Date.Date != 0 {
+		date = time.Unix(0, task.Fields.Date.Date*int64(time.Millisecond))
+	}
+	name := mainObject.Names.Basename
+	if name == "" {
+		name = mainObject.Names.URL
+	}
+	event := &MISPEventBody{
+		UUID:          newUUIDv5(mispNamespace, report.UUID),
+		Info:          fmt.Sprintf("ANY.RUN task %s: %s (%s)", report.UUID, name, task.Fields.Scores.Verdict.Text),
+		Date:          date.UTC().Format("2006-01-02"),
+		ThreatLevelID: getMISPThreatLevelID(task.Fields.Scores.Verdict.ThreatLevel),
+		Analysis:      "2",
+		Distribution:  fmt.Sprint(distribution),
+		Attribute:     make([]*MISPAttribute, 0),
+		Tag:           make([]*MISPTag, 0),
+	}
+	reference := fmt.Sprintf(taskURLFormat, report.UUID)
+	// MISP rejects an attribute with the same type and value as another one of the event
+	seen := make(map[string]bool)
+	addAttribute := func(attributeType, category, value string, toIDs bool, comment string) {
+		if value == "" || seen[attributeType+"|"+value] {
+			return
+		}
+		seen[attributeType+"|"+value] = true
+		event.Attribute = append(event.Attribute, &MISPAttribute{
+			UUID:     newUUIDv5(mispNamespace, report.UUID+"|"+attributeType+"|"+value),
+			Type:     attributeType,
+			Category: category,
+			Value:    value,
+			ToIDs:    toIDs,
+			Comment:  comment,
+		})
+	}
+
+	addAttribute("link", "External analysis", reference, false, "")
+	addAttribute("filename", "Payload delivery", mainObject.Names.Basename, false, "")
+	addAttribute("md5", "Payload delivery", mainObject.Hashes.Md5, true, "")
+	addAttribute("sha1", "Payload delivery", mainObject.Hashes.Sha1, true, "")
+	addAttribute("sha256", "Payload delivery", mainObject.Hashes.Sha256, true, "")
+	addAttribute("ssdeep", "Payload delivery", mainObject.Hashes.Ssdeep, false, "")
+	addAttribute("url", "Payload delivery", mainObject.Names.URL, true, "")
+	for _, proc := range report.Processes {
+		addAttribute("text", "Other", proc.Fields.Cmd, false, fmt.Sprintf("command line of process %d (%s)", proc.Fields.Pid, proc.Fields.Image))
+	}
+	domains, ips, urls := report.GetNetworkIOCs()
+	for _, domain := range domains {
+		addAttribute("domain", "Network activity", domain, true, "")
+	}
+	for _, ip := range ips {
+		addAttribute("ip-dst", "Network activity", ip, true, "")
+	}
+	for _, url := range urls {
+		addAttribute("url", "Network activity", url, true, "")
+	}
+
+	for _, tag := range task.Fields.Tags {
+		event.Tag = append(event.Tag, &MISPTag{Name: tag})
+	}
+	techniques := report.GetTechniques()
+	for _, technique := range techniques {
+		event.Tag = append(event.Tag, &MISPTag{Name: "mitre-attack:" + technique})
+	}
+	if len(techniques) > 0 {
+		event.Galaxy = append(event.Galaxy, newMISPAttackPatternGalaxy(techniques))
+	}
+	return &MISPEvent{
+		Event: event,
+	}
+}
+
+// newMISPAttackPatternGalaxy returns the mitre-attack-pattern galaxy with a cluster per technique. Clusters are
+// valued like "Process Injection - T1055" as in the galaxy shipped with MISP, an unknown sub-technique falls back
+// to the cluster of its parent and an unknown technique to its bare id.
+func newMISPAttackPatternGalaxy(techniques []string) *MISPGalaxy {
+	galaxy := &MISPGalaxy{
+		Type:      "mitre-attack-pattern",
+		Name:      "Attack Pattern",
+		Namespace: "mitre-attack",
+	}
+	seen := make(map[string]bool)
+	for _, technique := range techniques {
+		name, id := GetATTCKTechniqueName(technique)
+		value := id
+		if name != "" {
+			value = fmt.Sprintf("%s - %s", name, id)
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		galaxy.GalaxyCluster = append(galaxy.GalaxyCluster, &MISPGalaxyCluster{
+			Type:    "mitre-attack-pattern",
+			Value:   value,
+			TagName: fmt.Sprintf(`misp-galaxy:mitre-attack-pattern="%s"`, value),
+			Meta:    map[string][]string{"external_id": {id}},
+		})
+	}
+	return galaxy
+}
+
+// getMISPThreatLevelID maps the threat level of the verdict to 1 (high), 2 (medium), 3 (low) or 4 (undefined)
+func getMISPThreatLevelID(threatLevel int) string {
+	switch threatLevel {
+	case 2:
+		return "1"
+	case 1:
+		return "2"
+	case 0:
+		return "3"
+	}
+	return "4"
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	if limiter := NewRateLimiter(0, 10); limiter != nil {
+		t.Fatal("a rate of 0 must mean unlimited")
+	}
+	var unlimited *RateLimiter
+	if waited, err := unlimited.Wait(ctx); waited != 0 || err != nil {
+		t.Errorf("an unlimited limiter waited %s: %v", waited, err)
+	}
+
+	// 3 tokens upfront, then one every 50ms
+	limiter := NewRateLimiter(20, 3)
+	tests := []struct {
+		minWait time.Duration
+		maxWait time.Duration
+	}{
+		{0, 0},
+		{0, 0},
+		{0, 0},
+		{25 * time.Millisecond, 50 * time.Millisecond},
+		{25 * time.Millisecond, 50 * time.Millisecond},
+	}
+	for i, test := range tests {
+		waited, err := limiter.Wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if waited < test.minWait || waited > test.maxWait {
+			t.Errorf("event %d waited %s, want %s-%s", i, waited, test.minWait, test.maxWait)
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := limiter.Wait(cancelled); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
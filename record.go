@@ -0,0 +1,138 @@
+package main
+
+import "sort"
+
+type (
+	// TaskRecord is a flat view of a task report which is convenient for log shippers and jq
+	TaskRecord struct {
+		UUID        string            `json:"uuid"`
+		Type        string            `json:"type"`
+		RunType     string            `json:"run_type"`
+		Name        string            `json:"name,omitempty"`
+		URL         string            `json:"url,omitempty"`
+		Md5         string            `json:"md5,omitempty"`
+		Sha1        string            `json:"sha1,omitempty"`
+		Sha256      string            `json:"sha256,omitempty"`
+		Ssdeep      string            `json:"ssdeep,omitempty"`
+		Verdict     string            `json:"verdict"`
+		ThreatLevel int               `json:"threat_level"`
+		Significant bool              `json:"significant"`
+		Tags        []string          `json:"tags"`
+		Techniques  []string          `json:"techniques"`
+		Processes   []*ProcessRecord  `json:"processes"`
+		Incidents   []*IncidentRecord `json:"incidents"`
+		Domains     []string          `json:"domains,omitempty"`
+		IPs         []string          `json:"ips,omitempty"`
+		URLs        []string          `json:"urls,omitempty"`
+	}
+	ProcessRecord struct {
+		OID             string `json:"oid"`
+		Pid             int    `json:"pid"`
+		ParentPid       int    `json:"parent_pid"`
+		Image           string `json:"image"`
+		Cmd             string `json:"cmd"`
+		User            string `json:"user"`
+		IntegrityLevel  string `json:"integrity_level"`
+		ImportantReason string `json:"important_reason,omitempty"`
+	}
+	IncidentRecord struct {
+		ProcessOID  string   `json:"process_oid"`
+		ThreatLevel int      `json:"threat_level"`
+		Title       string   `json:"title"`
+		Techniques  []string `json:"techniques"`
+	}
+)
+
+func NewTaskRecord(report *TaskReport) *TaskRecord {
+	task := report.Task
+	mainObject := task.Fields.Public.Objects.MainObject
+	record := &TaskRecord{
+		UUID:        report.UUID,
+		Type:        mainObject.Type,
+		RunType:     task.Fields.Public.Objects.RunType,
+		Name:        mainObject.Names.Basename,
+		URL:         mainObject.Names.URL,
+		Md5:         mainObject.Hashes.Md5,
+		Sha1:        mainObject.Hashes.Sha1,
+		Sha256:      mainObject.Hashes.Sha256,
+		Ssdeep:      mainObject.Hashes.Ssdeep,
+		Verdict:     task.Fields.Scores.Verdict.Text,
+		ThreatLevel: task.Fields.Scores.Verdict.ThreatLevel,
+		Significant: task.Fields.Significant,
+		Tags:        task.Fields.Tags,
+		Techniques:  report.GetTechniques(),
+		Processes:   make([]*ProcessRecord, 0, len(report.Processes)),
+		Incidents:   make([]*IncidentRecord, 0, len(report.Incidents)),
+	}
+	if record.Tags == nil {
+		record.Tags = []string{}
+	}
+	for _, proc := range report.Processes {
+		record.Processes = append(record.Processes, &ProcessRecord{
+			OID:             proc.ID,
+			Pid:             proc.Fields.Pid,
+			ParentPid:       proc.Fields.ParentPID,
+			Image:           proc.Fields.Image,
+			Cmd:             proc.Fields.Cmd,
+			User:            proc.Fields.User.Name,
+			IntegrityLevel:  proc.Fields.User.Il,
+			ImportantReason: proc.Fields.Scores.ImportantReason,
+		})
+	}
+	for _, incident := range report.Incidents {
+		techniques := incident.Fields.Mitre
+		if techniques == nil {
+			techniques = []string{}
+		}
+		record.Incidents = append(record.Incidents, &IncidentRecord{
+			ProcessOID:  incident.Fields.ProcessOID.Value,
+			ThreatLevel: incident.Fields.Threatlevel,
+			Title:       incident.Fields.Title,
+			Techniques:  techniques,
+		})
+	}
+	record.Domains, record.IPs, record.URLs = report.GetNetworkIOCs()
+	return record
+}
+
+// GetTechniques returns the sorted list of distinct MITRE ATT&CK technique ids of all incidents
+func (report *TaskReport) GetTechniques() []string {
+	techniques := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, incident := range report.Incidents {
+		for _, technique := range incident.Fields.Mitre {
+			if !seen[technique] {
+				seen[technique] = true
+				techniques = append(techniques, technique)
+			}
+		}
+	}
+	sort.Strings(techniques)
+	return techniques
+}
+
+// GetNetworkIOCs returns the distinct contacted domains, IPs and URLs in order of appearance
+func (report *TaskReport) GetNetworkIOCs() (domains, ips, urls []string) {
+	seen := make(map[string]bool)
+	add := func(values []string, value string) []string {
+		if value == "" || seen[value] {
+			return values
+		}
+		seen[value] = true
+		return append(values, value)
+	}
+	for _, query := range report.DNSQueries {
+		domains = add(domains, query.Fields.Domain)
+		for _, ip := range query.Fields.IPs {
+			ips = add(ips, ip)
+		}
+	}
+	for _, connection := range report.Connections {
+		ips = add(ips, connection.Fields.DstIP)
+	}
+	for _, request := range report.HTTPRequests {
+		domains = add(domains, request.Fields.Host)
+		urls = add(urls, request.Fields.URL)
+	}
+	return domains, ips, urls
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// withRetry runs the operation and, if the connection drops meanwhile, reconnects with exponential backoff
+// and runs it again from scratch, which re-issues its subscription on the new session. Errors replied by the
+// server (e.g. ErrSubscriptionRejected) leave the session alive and are returned as is.
+func (client *AppAnyClient) withRetry(ctx context.Context, op func() error) error {
+	retries := 0
+	for {
+		session := client.getSession()
+		err := op()
+		if err == nil || !session.isBroken() {
+			return err
+		}
+		if retries >= client.config.MaxRetries {
+			return fmt.Errorf("connection lost after %d retries: %w", retries, err)
+		}
+		delay := client.getBackoff(retries)
+		retries++
+		log.Warn().Err(err).Msgf("connection lost, reconnecting in %s (retry %d/%d)", delay, retries, client.config.MaxRetries)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := client.reconnect(session); err != nil {
+			var rejected *ErrVersionRejected
+			if errors.As(err, &rejected) {
+				return err
+			}
+			log.Warn().Err(err).Msg("failed to reconnect")
+		}
+	}
+}
+
+// getBackoff returns the delay before the n-th retry (starting from 0)
+func (client *AppAnyClient) getBackoff(retries int) time.Duration {
+	delay := client.config.MinBackoff
+	for i := 0; i < retries && delay < client.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > client.config.MaxBackoff {
+		delay = client.config.MaxBackoff
+	}
+	return delay
+}
+
+// reconnect redials on the next host and redoes the handshake, unless the broken session
+// has already been replaced by a concurrent caller
+func (client *AppAnyClient) reconnect(broken *ddpSession) error {
+	client.reconnectMu.Lock()
+	defer client.reconnectMu.Unlock()
+	if client.getSession() != broken {
+		return nil
+	}
+	client.hostIndex = (client.hostIndex + 1) % len(client.config.Hosts)
+	session, err := client.dial()
+	if err != nil {
+		return err
+	}
+	// the session is only handed to callers once connected, the server rejects anything sent before
+	if err := session.connect(); err != nil {
+		return fmt.Errorf("in connect: %w", err)
+	}
+	client.mu.Lock()
+	client.session = session
+	client.mu.Unlock()
+	log.Info().Msgf("reconnected to %s", client.config.Hosts[client.hostIndex])
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	// SockJS session ids are drawn from this alphabet
+	sockJSSessionLetters  = "abcdefghijklmnopqrstuvwxyz012345"
+	sockJSSessionIDLength = 8
+	sockJSInfoTimeout     = 10 * time.Second
+
+	// the first byte of a frame tells its type
+	sockJSOpenFrame      = 'o'
+	sockJSHeartbeatFrame = 'h'
+	sockJSArrayFrame     = 'a'
+	sockJSMessageFrame   = 'm'
+	sockJSCloseFrame     = 'c'
+)
+
+type (
+	// SockJSInfo is the reply of the /sockjs/info endpoint
+	SockJSInfo struct {
+		Websocket    bool     `json:"websocket"`
+		Origins      []string `json:"origins"`
+		CookieNeeded bool     `json:"cookie_needed"`
+		Entropy      int64    `json:"entropy"`
+	}
+	// sockJSFrame is a decoded websocket message, array frames may carry several messages
+	sockJSFrame struct {
+		Type        byte
+		Messages    [][]byte
+		CloseCode   int
+		CloseReason string
+	}
+)
+
+// encodeSockJSFrame marshals the messages and wraps them into a client frame: ["<json>",...]
+func encodeSockJSFrame(msgs ...interface{}) ([]byte, error) {
+	encoded := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		bytes, err := json.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("in Marshal: %s", err)
+		}
+		encoded = append(encoded, string(bytes))
+	}
+	return json.Marshal(encoded)
+}
+
+// decodeSockJSFrame decodes a server frame: o, h, a["<json>",...], m"<json>" or c[code,"reason"]
+func decodeSockJSFrame(data []byte) (*sockJSFrame, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty frame")
+	}
+	frame := &sockJSFrame{Type: data[0]}
+	switch frame.Type {
+	case sockJSOpenFrame, sockJSHeartbeatFrame:
+	case sockJSArrayFrame:
+		var msgs []string
+		if err := json.Unmarshal(data[1:], &msgs); err != nil {
+			return nil, fmt.Errorf("invalid array frame: %s", err)
+		}
+		for _, msg := range msgs {
+			frame.Messages = append(frame.Messages, []byte(msg))
+		}
+	case sockJSMessageFrame:
+		var msg string
+		if err := json.Unmarshal(data[1:], &msg); err != nil {
+			return nil, fmt.Errorf("invalid message frame: %s", err)
+		}
+		frame.Messages = [][]byte{[]byte(msg)}
+	case sockJSCloseFrame:
+		var payload []json.RawMessage
+		if err := json.Unmarshal(data[1:], &payload); err != nil || len(payload) != 2 {
+			return nil, fmt.Errorf("invalid close frame: %s", data)
+		}
+		if err := json.Unmarshal(payload[0], &frame.CloseCode); err != nil {
+			return nil, fmt.Errorf("invalid close code: %s", err)
+		}
+		if err := json.Unmarshal(payload[1], &frame.CloseReason); err != nil {
+			return nil, fmt.Errorf("invalid close reason: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown frame type '%c'", frame.Type)
+	}
+	return frame, nil
+}
+
+// NewSockJSEndpoint returns a websocket url for a new session on the host, following the
+// /sockjs/<server>/<session>/websocket scheme where both ids are picked by the client
+func NewSockJSEndpoint(host string) string {
+	sessionID := make([]byte, sockJSSessionIDLength)
+	for i := range sessionID {
+		sessionID[i] = sockJSSessionLetters[rand.Intn(len(sockJSSessionLetters))]
+	}
+	return fmt.Sprintf("wss://%s/sockjs/%03d/%s/websocket", host, rand.Intn(1000), sessionID)
+}
+
+// ProbeSockJSInfo asks the host whether the websocket transport is available
+func ProbeSockJSInfo(host string, reqHeader http.Header) (*SockJSInfo, error) {
+	url := fmt.Sprintf("https://%s/sockjs/info?cb=%s", host, generateRandStr(10))
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("in NewRequest: %s", err)
+	}
+	for key, values := range reqHeader {
+		req.Header[key] = values
+	}
+	httpClient := &http.Client{Timeout: sockJSInfoTimeout}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("in Do: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status '%s'", resp.Status)
+	}
+	info := new(SockJSInfo)
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return nil, fmt.Errorf("in Decode: %s", err)
+	}
+	if !info.Websocket {
+		return nil, fmt.Errorf("websocket transport is disabled on '%s'", host)
+	}
+	return info, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDecodeSockJSFrame(t *testing.T) {
+	tests := []struct {
+		data        string
+		frameType   byte
+		messages    []string
+		closeCode   int
+		closeReason string
+		err         bool
+	}{
+		{data: `o`, frameType: sockJSOpenFrame},
+		{data: `h`, frameType: sockJSHeartbeatFrame},
+		{data: `a["{\"msg\":\"ping\"}"]`, frameType: sockJSArrayFrame, messages: []string{`{"msg":"ping"}`}},
+		{
+			data:      `a["{\"msg\":\"connected\",\"session\":\"0\"}","{\"msg\":\"ping\"}","{\"msg\":\"ready\",\"subs\":[\"1\"]}"]`,
+			frameType: sockJSArrayFrame,
+			messages:  []string{`{"msg":"connected","session":"0"}`, `{"msg":"ping"}`, `{"msg":"ready","subs":["1"]}`},
+		},
+		{data: `a[]`, frameType: sockJSArrayFrame},
+		{data: `m"{\"msg\":\"pong\"}"`, frameType: sockJSMessageFrame, messages: []string{`{"msg":"pong"}`}},
+		{data: `c[3000,"Go away!"]`, frameType: sockJSCloseFrame, closeCode: 3000, closeReason: "Go away!"},
+		{data: ``, err: true},
+		{data: `x`, err: true},
+		{data: `a[`, err: true},
+		{data: `a[1]`, err: true},
+		{data: `m`, err: true},
+		{data: `c[3000]`, err: true},
+		{data: `c["3000","Go away!"]`, err: true},
+	}
+	for _, test := range tests {
+		frame, err := decodeSockJSFrame([]byte(test.data))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: decoded an invalid frame", test.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.data, err)
+			continue
+		}
+		var messages []string
+		for _, msg := range frame.Messages {
+			messages = append(messages, string(msg))
+		}
+		if frame.Type != test.frameType || fmt.Sprint(messages) != fmt.Sprint(test.messages) ||
+			frame.CloseCode != test.closeCode || frame.CloseReason != test.closeReason {
+			t.Errorf("%s: got %c %q %d %q", test.data, frame.Type, messages, frame.CloseCode, frame.CloseReason)
+		}
+	}
+}
+
+func TestEncodeSockJSFrame(t *testing.T) {
+	data, err := encodeSockJSFrame(newDDPConnect(), &ddpPong{Msg: "pong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `["{\"msg\":\"connect\",\"version\":\"1\",\"support\":[\"1\",\"pre2\",\"pre1\"]}","{\"msg\":\"pong\"}"]`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
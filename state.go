@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+type (
+	// CrawlState is saved after every crawled page so that an interrupted crawl can be resumed.
+	// Public tasks are listed newest-first, so indexes shift while crawling; the set of exported
+	// uuids is what prevents a resumed crawl from exporting the same task twice. Tasks crawled since
+	// the last save are crawled again by the resumed crawl. Batch and incremental crawls keep their
+	// own set, so that neither kind of crawl forgets the tasks the other one depends on.
+	CrawlState struct {
+		path string
+		// the range of the last batch crawl and the tasks it exported
+		NextIndex     uint
+		EndIndex      uint
+		batchExported exportedTasks
+		// the newest task seen by the last completed incremental crawl and the tasks exported since
+		Newest      TaskMarker
+		newExported exportedTasks
+	}
+	// exportedTasks is the set of uuids of the exported tasks
+	exportedTasks  map[string]bool
+	crawlStateFile struct {
+		Batch       batchStateFile       `json:"batch"`
+		Incremental incrementalStateFile `json:"incremental"`
+	}
+	batchStateFile struct {
+		NextIndex uint     `json:"next_index"`
+		EndIndex  uint     `json:"end_index"`
+		Exported  []string `json:"exported"`
+	}
+	incrementalStateFile struct {
+		Newest   TaskMarker `json:"newest"`
+		Exported []string   `json:"exported"`
+	}
+	TaskMarker struct {
+		UUID string `json:"uuid"`
+		Date int64  `json:"date"`
+	}
+)
+
+// LoadCrawlState reads the state file at the path, an empty state is returned if it does not exist yet
+func LoadCrawlState(path string) (*CrawlState, error) {
+	state := &CrawlState{
+		path:          path,
+		batchExported: make(exportedTasks),
+		newExported:   make(exportedTasks),
+	}
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, fmt.Errorf("in ReadFile: %s", err)
+	}
+	var stateFile crawlStateFile
+	if err := json.Unmarshal(bytes, &stateFile); err != nil {
+		return nil, fmt.Errorf("in Unmarshal: %s", err)
+	}
+	state.NextIndex = stateFile.Batch.NextIndex
+	state.EndIndex = stateFile.Batch.EndIndex
+	state.batchExported.addAll(stateFile.Batch.Exported)
+	state.Newest = stateFile.Incremental.Newest
+	state.newExported.addAll(stateFile.Incremental.Exported)
+	return state, nil
+}
+
+// Save writes the state to a temporary file first so a crash never leaves a truncated state file
+func (state *CrawlState) Save() error {
+	stateFile := &crawlStateFile{
+		Batch: batchStateFile{
+			NextIndex: state.NextIndex,
+			EndIndex:  state.EndIndex,
+			Exported:  state.batchExported.list(),
+		},
+		Incremental: incrementalStateFile{
+			Newest:   state.Newest,
+			Exported: state.newExported.list(),
+		},
+	}
+	bytes, err := json.MarshalIndent(stateFile, "", " ")
+	if err != nil {
+		return fmt.Errorf("in MarshalIndent: %s", err)
+	}
+	tmpPath := state.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, bytes, 0644); err != nil {
+		return fmt.Errorf("in WriteFile: %s", err)
+	}
+	if err := os.Rename(tmpPath, state.path); err != nil {
+		return fmt.Errorf("in Rename: %s", err)
+	}
+	return nil
+}
+
+// CanResume reports whether the last crawl stopped before reaching its end index
+func (state *CrawlState) CanResume() bool {
+	return state.NextIndex < state.EndIndex
+}
+
+// ResetBatch forgets the last batch crawl, once a new one starts
+func (state *CrawlState) ResetBatch() {
+	state.NextIndex = 0
+	state.EndIndex = 0
+	state.batchExported = make(exportedTasks)
+}
+
+// ClearNewExported forgets the tasks exported by incremental crawls, once the marker covers them
+func (state *CrawlState) ClearNewExported() {
+	state.newExported = make(exportedTasks)
+}
+
+func (tasks exportedTasks) addAll(taskUuids []string) {
+	for _, taskUuid := range taskUuids {
+		tasks[taskUuid] = true
+	}
+}
+
+// list returns the sorted uuids so that the state file is stable
+func (tasks exportedTasks) list() []string {
+	taskUuids := make([]string, 0, len(tasks))
+	for taskUuid := range tasks {
+		taskUuids = append(taskUuids, taskUuid)
+	}
+	sort.Strings(taskUuids)
+	return taskUuids
+}
+
+func NewTaskMarker(task *RawTask) TaskMarker {
+	return TaskMarker{
+		UUID: task.Fields.UUID,
+		Date: task.Fields.Date.Date,
+	}
+}
+
+// IsReached reports whether the task is the marked one or older than it
+func (marker TaskMarker) IsReached(task *RawTask) bool {
+	if marker.UUID == "" {
+		return false
+	}
+	if task.Fields.UUID == marker.UUID {
+		return true
+	}
+	// the marked task may have been deleted, fall back to its date
+	return marker.Date != 0 && task.Fields.Date.Date != 0 && task.Fields.Date.Date < marker.Date
+}
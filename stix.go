@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	stixSpecVersion     = "2.1"
+	stixTimestampFormat = "2006-01-02T15:04:05.000Z"
+	attackURLFormat     = "https://attack.mitre.org/techniques/%s/"
+	// objects shared by tasks must not differ between bundles, so they are not dated after any task
+	stixSharedTimestamp = "2020-01-01T00:00:00.000Z"
+)
+
+// stixNamespace is the UUIDv5 namespace of the deterministic ids of STIX cyber-observable objects
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+type (
+	STIXBundle struct {
+		Type    string       `json:"type"`
+		ID      string       `json:"id"`
+		Objects []STIXObject `json:"objects"`
+	}
+	// STIXObject is any STIX object, all of them embed stixCommon
+	STIXObject interface {
+		GetID() string
+	}
+	// stixCommon holds the common properties, SCOs have neither created nor modified
+	stixCommon struct {
+		Type        string `json:"type"`
+		SpecVersion string `json:"spec_version"`
+		ID          string `json:"id"`
+		Created     string `json:"created,omitempty"`
+		Modified    string `json:"modified,omitempty"`
+	}
+	stixExternalReference struct {
+		SourceName string `json:"source_name"`
+		URL        string `json:"url,omitempty"`
+		ExternalID string `json:"external_id,omitempty"`
+	}
+	stixFile struct {
+		stixCommon
+		Hashes map[string]string `json:"hashes,omitempty"`
+		Name   string            `json:"name,omitempty"`
+	}
+	stixURL struct {
+		stixCommon
+		Value string `json:"value"`
+	}
+	stixMalwareAnalysis struct {
+		stixCommon
+		Product            string                   `json:"product"`
+		ResultName         string                   `json:"result_name,omitempty"`
+		Result             string                   `json:"result"`
+		SampleRef          string                   `json:"sample_ref,omitempty"`
+		ExternalReferences []*stixExternalReference `json:"external_references"`
+	}
+	stixIndicator struct {
+		stixCommon
+		Name           string   `json:"name"`
+		IndicatorTypes []string `json:"indicator_types"`
+		Pattern        string   `json:"pattern"`
+		PatternType    string   `json:"pattern_type"`
+		ValidFrom      string   `json:"valid_from"`
+	}
+	stixMalware struct {
+		stixCommon
+		Name     string `json:"name"`
+		IsFamily bool   `json:"is_family"`
+	}
+	stixAttackPattern struct {
+		stixCommon
+		Name               string                   `json:"name"`
+		ExternalReferences []*stixExternalReference `json:"external_references"`
+	}
+	stixRelationship struct {
+		stixCommon
+		RelationshipType string `json:"relationship_type"`
+		SourceRef        string `json:"source_ref"`
+		TargetRef        string `json:"target_ref"`
+	}
+)
+
+func (common *stixCommon) GetID() string {
+	return common.ID
+}
+
+// NewSTIXBundle wraps the objects into a bundle with a random id
+func NewSTIXBundle(objects []STIXObject) *STIXBundle {
+	return &STIXBundle{
+		Type:    "bundle",
+		ID:      "bundle--" + generateUUID(),
+		Objects: objects,
+	}
+}
+
+// NewSTIXObjects maps the task to a malware-analysis SDO of its sample and, unless the task has no threats,
+// an indicator of the sample hashes. Tags become malware families and ATT&CK techniques attack patterns,
+// both related to the indicator, or to the analysis without indicator. Ids are derived from the task and
+// the names, so that objects shared by several tasks keep the same id and the same properties.
+func NewSTIXObjects(report *TaskReport) []STIXObject {
+	task := report.Task
+	mainObject := task.Fields.Public.Objects.MainObject
+	timestamp := formatSTIXTimestamp(task.Fields.Date.Date)
+	newCommon := func(objectType, name string) stixCommon {
+		return stixCommon{
+			Type:        objectType,
+			SpecVersion: stixSpecVersion,
+			ID:          newSTIXID(objectType, name),
+			Created:     timestamp,
+			Modified:    timestamp,
+		}
+	}
+	newSharedCommon := func(objectType, name string) stixCommon {
+		common := newCommon(objectType, name)
+		common.Created, common.Modified = stixSharedTimestamp, stixSharedTimestamp
+		return common
+	}
+	var objects []STIXObject
+
+	var sample STIXObject
+	var sampleID, pattern string
+	if hashes := getSTIXHashes(report); len(hashes) > 0 {
+		file := &stixFile{
+			stixCommon: stixCommon{Type: "file", SpecVersion: stixSpecVersion},
+			Hashes:     hashes,
+			Name:       mainObject.Names.Basename,
+		}
+		file.ID = newSTIXID("file", toCanonicalJSON(map[string]interface{}{"hashes": getSTIXIDHash(hashes), "name": file.Name}))
+		sample, sampleID, pattern = file, file.ID, getSTIXFilePattern(hashes)
+	} else if mainObject.Names.URL != "" {
+		url := &stixURL{
+			stixCommon: stixCommon{Type: "url", SpecVersion: stixSpecVersion},
+			Value:      mainObject.Names.URL,
+		}
+		url.ID = newSTIXID("url", toCanonicalJSON(map[string]interface{}{"value": url.Value}))
+		sample, sampleID, pattern = url, url.ID, fmt.Sprintf("[url:value = '%s']", escapeSTIXString(url.Value))
+	}
+	if sample != nil {
+		objects = append(objects, sample)
+	}
+
+	result := getSTIXResult(task.Fields.Scores.Verdict.ThreatLevel)
+	// the malware family is the first tag if any, the verdict text only restates the result
+	var resultName string
+	if len(task.Fields.Tags) > 0 {
+		resultName = task.Fields.Tags[0]
+	}
+	analysis := &stixMalwareAnalysis{
+		stixCommon: newCommon("malware-analysis", report.UUID),
+		Product:    "any.run",
+		ResultName: resultName,
+		Result:     result,
+		SampleRef:  sampleID,
+		ExternalReferences: []*stixExternalReference{{
+			SourceName: "app.any.run",
+			URL:        fmt.Sprintf(taskURLFormat, report.UUID),
+			ExternalID: report.UUID,
+		}},
+	}
+	objects = append(objects, analysis)
+
+	var families []string
+	for _, tag := range task.Fields.Tags {
+		malware := &stixMalware{
+			stixCommon: newSharedCommon("malware", strings.ToLower(tag)),
+			Name:       tag,
+			IsFamily:   true,
+		}
+		families = append(families, malware.ID)
+		objects = append(objects, malware, &stixRelationship{
+			stixCommon:       newCommon("relationship", analysis.ID+malware.ID),
+			RelationshipType: "dynamic-analysis-of",
+			SourceRef:        analysis.ID,
+			TargetRef:        malware.ID,
+		})
+	}
+
+	// the analysis stands for the task when there is no indicator
+	sourceID, relationshipType := analysis.ID, "related-to"
+	if pattern != "" && result != "benign" {
+		indicator := &stixIndicator{
+			stixCommon:     newCommon("indicator", report.UUID+pattern),
+			Name:           getSTIXIndicatorName(report),
+			IndicatorTypes: []string{"malicious-activity"},
+			Pattern:        pattern,
+			PatternType:    "stix",
+			ValidFrom:      timestamp,
+		}
+		objects = append(objects, indicator)
+		for _, family := range families {
+			objects = append(objects, &stixRelationship{
+				stixCommon:       newCommon("relationship", indicator.ID+family),
+				RelationshipType: "indicates",
+				SourceRef:        indicator.ID,
+				TargetRef:        family,
+			})
+		}
+		sourceID, relationshipType = indicator.ID, "indicates"
+	}
+	for _, technique := range report.GetTechniques() {
+		attackPattern := &stixAttackPattern{
+			stixCommon: newSharedCommon("attack-pattern", technique),
+			Name:       technique,
+			ExternalReferences: []*stixExternalReference{{
+				SourceName: "mitre-attack",
+				URL:        fmt.Sprintf(attackURLFormat, strings.Replace(technique, ".", "/", 1)),
+				ExternalID: technique,
+			}},
+		}
+		objects = append(objects, attackPattern, &stixRelationship{
+			stixCommon:       newCommon("relationship", sourceID+attackPattern.ID),
+			RelationshipType: relationshipType,
+			SourceRef:        sourceID,
+			TargetRef:        attackPattern.ID,
+		})
+		for _, family := range families {
+			objects = append(objects, &stixRelationship{
+				stixCommon:       newSharedCommon("relationship", family+attackPattern.ID),
+				RelationshipType: "uses",
+				SourceRef:        family,
+				TargetRef:        attackPattern.ID,
+			})
+		}
+	}
+	return objects
+}
+
+// getSTIXHashes returns the hashes of the main object by their STIX hash algorithm names
+func getSTIXHashes(report *TaskReport) map[string]string {
+	hashes := make(map[string]string)
+	mainHashes := report.Task.Fields.Public.Objects.MainObject.Hashes
+	for name, value := range map[string]string{
+		"MD5":     mainHashes.Md5,
+		"SHA-1":   mainHashes.Sha1,
+		"SHA-256": mainHashes.Sha256,
+		"SSDEEP":  mainHashes.Ssdeep,
+	} {
+		if value != "" {
+			hashes[name] = value
+		}
+	}
+	return hashes
+}
+
+// getSTIXIDHash returns the single hash the id of a file is derived from, picked in the same order
+// as the reference implementation so that ids match those of other producers
+func getSTIXIDHash(hashes map[string]string) map[string]string {
+	for _, name := range []string{"MD5", "SHA-1", "SHA-256"} {
+		if value, ok := hashes[name]; ok {
+			return map[string]string{name: value}
+		}
+	}
+	return hashes
+}
+
+// getSTIXFilePattern matches any of the hashes, fuzzy hashes are left out
+func getSTIXFilePattern(hashes map[string]string) string {
+	var comparisons []string
+	for _, name := range []string{"SHA-256", "SHA-1", "MD5"} {
+		if value, ok := hashes[name]; ok {
+			comparisons = append(comparisons, fmt.Sprintf("file:hashes.'%s' = '%s'", name, escapeSTIXString(value)))
+		}
+	}
+	if len(comparisons) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(comparisons, " OR ") + "]"
+}
+
+func getSTIXIndicatorName(report *TaskReport) string {
+	mainObject := report.Task.Fields.Public.Objects.MainObject
+	name := mainObject.Names.Basename
+	if name == "" {
+		name = mainObject.Names.URL
+	}
+	if tags := report.Task.Fields.Tags; len(tags) > 0 {
+		return fmt.Sprintf("%s (%s)", name, strings.Join(tags, ", "))
+	}
+	return name
+}
+
+// getSTIXResult maps the threat level of the verdict to the malware-result-ov vocabulary
+func getSTIXResult(threatLevel int) string {
+	switch threatLevel {
+	case 0:
+		return "benign"
+	case 1:
+		return "suspicious"
+	case 2:
+		return "malicious"
+	}
+	return "unknown"
+}
+
+func formatSTIXTimestamp(millis int64) string {
+	date := time.Now()
+	if millis != 0 {
+		date = time.Unix(0, millis*int64(time.Millisecond))
+	}
+	return date.UTC().Format(stixTimestampFormat)
+}
+
+func escapeSTIXString(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
+// toCanonicalJSON marshals the value with sorted keys, as expected for the ids of cyber-observable objects
+func toCanonicalJSON(value map[string]interface{}) string {
+	for key, v := range value {
+		if s, ok := v.(string); ok && s == "" {
+			delete(value, key)
+		}
+	}
+	buffer, _ := json.Marshal(value)
+	return string(buffer)
+}
+
+// newSTIXID returns "<type>--<UUIDv5 of the name>"
+func newSTIXID(objectType, name string) string {
+	return objectType + "--" + newUUIDv5(stixNamespace, name)
+}
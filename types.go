@@ -6,25 +6,22 @@ import (
 	"strings"
 )
 
-var (
-	SupportedTaskExtensions = map[string]string{
-		"PE EXE":           "0",
-		"Microsoft Office": "6",
-	}
-	SupportedTaskDetections = map[string]int{
-		"No threats": 0,
-		"Suspicious": 1,
-		"Malicious":  2,
-	}
-)
-
 type (
+	// PublicTasksCounterResult is the result of the "publicTasksCounter" method
 	PublicTasksCounterResult struct {
-		Msg    string `json:"msg"`
-		ID     string `json:"id"`
-		Result struct {
-			Count uint `json:"count"`
-		} `json:"result"`
+		Count uint `json:"count"`
+	}
+	TaskExistsResult struct {
+		Msg        string `json:"msg"`
+		Collection string `json:"collection"`
+		ID         string `json:"id"`
+		Fields     struct {
+			TaskID       string `json:"taskId"`
+			TaskObjectID struct {
+				Type  string `json:"$type"`
+				Value string `json:"$value"`
+			} `json:"taskObjectId"`
+		} `json:"fields"`
 	}
 	// This is not a complete structure because many ignored fields are verbose or just used internally by App.Any.Run
 	// runType can be "file", "url", "download"
@@ -74,6 +71,9 @@ type (
 				} `json:"verdict"`
 			} `json:"scores"`
 			UUID string `json:"uuid"`
+			Date struct {
+				Date int64 `json:"$date"`
+			} `json:"date"`
 		} `json:"fields"`
 	}
 
@@ -134,27 +134,10 @@ type (
 			ImageUp   string `json:"imageUp"`
 			Important bool   `json:"important"`
 			Scores    struct {
-				Specs struct {
-					Network           bool `json:"network"`
-					UacRequest        bool `json:"uac_request"`
-					KnownThreat       bool `json:"known_threat"`
-					Injects           bool `json:"injects"`
-					NetworkLoader     bool `json:"network_loader"`
-					ServiceLuncher    bool `json:"service_luncher"`
-					ExecutableDropped bool `json:"executable_dropped"`
-					Multiprocessing   bool `json:"multiprocessing"`
-					CrashedApps       bool `json:"crashed_apps"`
-					DebugOutput       bool `json:"debug_output"`
-					Stealing          bool `json:"stealing"`
-					Exploitable       bool `json:"exploitable"`
-					StaticDetections  bool `json:"static_detections"`
-					SuspStruct        bool `json:"susp_struct"`
-					Autostart         bool `json:"autostart"`
-					LowAccess         bool `json:"low_access"`
-				} `json:"specs"`
-				Type            string `json:"type"`
-				Important       bool   `json:"important"`
-				ImportantReason string `json:"important_reason"`
+				Specs           ProcessSpecs `json:"specs"`
+				Type            string       `json:"type"`
+				Important       bool         `json:"important"`
+				ImportantReason string       `json:"important_reason"`
 				ImportantSince  struct {
 					Date int64 `json:"$date"`
 				} `json:"important_since"`
@@ -173,6 +156,26 @@ type (
 		} `json:"fields"`
 	}
 
+	// ProcessSpecs are the behaviours detected for a process
+	ProcessSpecs struct {
+		Network           bool `json:"network"`
+		UacRequest        bool `json:"uac_request"`
+		KnownThreat       bool `json:"known_threat"`
+		Injects           bool `json:"injects"`
+		NetworkLoader     bool `json:"network_loader"`
+		ServiceLuncher    bool `json:"service_luncher"`
+		ExecutableDropped bool `json:"executable_dropped"`
+		Multiprocessing   bool `json:"multiprocessing"`
+		CrashedApps       bool `json:"crashed_apps"`
+		DebugOutput       bool `json:"debug_output"`
+		Stealing          bool `json:"stealing"`
+		Exploitable       bool `json:"exploitable"`
+		StaticDetections  bool `json:"static_detections"`
+		SuspStruct        bool `json:"susp_struct"`
+		Autostart         bool `json:"autostart"`
+		LowAccess         bool `json:"low_access"`
+	}
+
 	RawIncident struct {
 		Msg        string `json:"msg"`
 		Collection string `json:"collection"`
@@ -194,8 +197,113 @@ type (
 			Mitre []string `json:"mitre"`
 		} `json:"fields"`
 	}
+
+	RawDNSQuery struct {
+		Msg        string `json:"msg"`
+		Collection string `json:"collection"`
+		ID         string `json:"id"`
+		Fields     struct {
+			Task struct {
+				Type  string `json:"$type"`
+				Value string `json:"$value"`
+			} `json:"task"`
+			Domain     string   `json:"domain"`
+			IPs        []string `json:"ips"`
+			Reputation string   `json:"reputation"`
+			Times      struct {
+				Request struct {
+					Date int64 `json:"$date"`
+				} `json:"request"`
+				Response struct {
+					Date int64 `json:"$date"`
+				} `json:"response"`
+			} `json:"times"`
+		} `json:"fields"`
+	}
+
+	// the owning process is referenced by both its object id and its pid, see RawProcess
+	RawConnection struct {
+		Msg        string `json:"msg"`
+		Collection string `json:"collection"`
+		ID         string `json:"id"`
+		Fields     struct {
+			Task struct {
+				Type  string `json:"$type"`
+				Value string `json:"$value"`
+			} `json:"task"`
+			ProcessOID struct {
+				Type  string `json:"$type"`
+				Value string `json:"$value"`
+			} `json:"processOID"`
+			Pid        int    `json:"pid"`
+			Protocol   string `json:"protocol"`
+			SrcIP      string `json:"srcIP"`
+			SrcPort    int    `json:"srcPort"`
+			DstIP      string `json:"dstIP"`
+			DstPort    int    `json:"dstPort"`
+			Country    string `json:"country"`
+			ASN        string `json:"asn"`
+			Reputation string `json:"reputation"`
+			Times      struct {
+				Created struct {
+					Date int64 `json:"$date"`
+				} `json:"created"`
+				Closed struct {
+					Date int64 `json:"$date"`
+				} `json:"closed"`
+			} `json:"times"`
+		} `json:"fields"`
+	}
+
+	RawHTTPRequest struct {
+		Msg        string `json:"msg"`
+		Collection string `json:"collection"`
+		ID         string `json:"id"`
+		Fields     struct {
+			Task struct {
+				Type  string `json:"$type"`
+				Value string `json:"$value"`
+			} `json:"task"`
+			ProcessOID struct {
+				Type  string `json:"$type"`
+				Value string `json:"$value"`
+			} `json:"processOID"`
+			Pid        int    `json:"pid"`
+			Method     string `json:"method"`
+			URL        string `json:"url"`
+			Host       string `json:"host"`
+			IP         string `json:"ip"`
+			Port       int    `json:"port"`
+			UserAgent  string `json:"userAgent"`
+			Reputation string `json:"reputation"`
+			Response   struct {
+				Status      int    `json:"status"`
+				ContentType string `json:"contentType"`
+				Size        int64  `json:"size"`
+			} `json:"response"`
+			Times struct {
+				Request struct {
+					Date int64 `json:"$date"`
+				} `json:"request"`
+				Response struct {
+					Date int64 `json:"$date"`
+				} `json:"response"`
+			} `json:"times"`
+		} `json:"fields"`
+	}
 )
 
+// TaskReport gathers all tabs crawled for a task, disabled tabs are left empty
+type TaskReport struct {
+	UUID         string            `json:"uuid"`
+	Task         *RawTask          `json:"task"`
+	Processes    []*RawProcess     `json:"processes,omitempty"`
+	Incidents    []*RawIncident    `json:"incidents,omitempty"`
+	DNSQueries   []*RawDNSQuery    `json:"dns_queries,omitempty"`
+	Connections  []*RawConnection  `json:"connections,omitempty"`
+	HTTPRequests []*RawHTTPRequest `json:"http_requests,omitempty"`
+}
+
 func (task *RawTask) GetIdentity() string {
 	mainObject := task.Fields.Public.Objects.MainObject
 	format := "UUID: %s, MD5: %s, name: %s"
@@ -208,6 +316,26 @@ func (task *RawTask) GetIdentity() string {
 	return "[unknown]"
 }
 
+// GetProcess returns the process which sent the request, or nil if it is not in the list
+func (request *RawHTTPRequest) GetProcess(processes []*RawProcess) *RawProcess {
+	for _, process := range processes {
+		if process.ID == request.Fields.ProcessOID.Value {
+			return process
+		}
+	}
+	return nil
+}
+
+// GetProcess returns the process which owns the connection, or nil if it is not in the list
+func (connection *RawConnection) GetProcess(processes []*RawProcess) *RawProcess {
+	for _, process := range processes {
+		if process.ID == connection.Fields.ProcessOID.Value {
+			return process
+		}
+	}
+	return nil
+}
+
 func ToJson(i interface{}) string {
 	buffer, err := json.MarshalIndent(i, "", " ")
 	if err != nil {
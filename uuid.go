@@ -0,0 +1,31 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"crypto/sha1"
+	"fmt"
+)
+
+// newUUIDv5 returns the name based UUID of the name in the namespace
+func newUUIDv5(namespace [16]byte, name string) string {
+	hash := sha1.New()
+	hash.Write(namespace[:])
+	hash.Write([]byte(name))
+	uuid := hash.Sum(nil)[:16]
+	uuid[6] = (uuid[6] & 0x0f) | 0x50
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return formatUUID(uuid)
+}
+
+// generateUUID returns a random UUIDv4
+func generateUUID() string {
+	uuid := make([]byte, 16)
+	crand.Read(uuid)
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return formatUUID(uuid)
+}
+
+func formatUUID(uuid []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}